@@ -20,11 +20,18 @@ hostkey = config/hostkey${i}.pem
 api_address = 127.0.0.1:${apiPort}
 p2p_address = 127.0.0.1:${p2pPort}
 maxTTL = 86400
-preConfPeer1 = 127.0.0.1:${neighbor1}
-preConfPeer2 = 127.0.0.1:${neighbor2}
-preConfPeer3 = 127.0.0.1:${neighbor3}
+bootstrapPeers = 127.0.0.1:${neighbor1}, 127.0.0.1:${neighbor2}, 127.0.0.1:${neighbor3}
+peerCache = config/peers${i}.cache
+storageFile = config/storage${i}.log
+routingTableFile = config/routingTable${i}.json
+transport = tcp
+staticPuzzleDifficulty = 0
+dynamicPuzzleDifficulty = 0
 k = 5
 a = 3
+minReplication = 1
+maxReplication = 5
+refreshInterval = 3600
 EOF
 
 done
@@ -0,0 +1,219 @@
+package dht
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"time"
+)
+
+//listens for TCP connections for API calls
+func (thisNode *Node) startAPIMessageDispatcher(ctx context.Context) {
+	defer thisNode.wg.Done()
+	//we listen on the specified API address from the configuration file
+	l := thisNode.apiListener
+	defer l.Close()
+	log.Debug("[SUCCESS] MAIN: APIMessageDispatcher Listening on ", l.Addr())
+
+	// closed when no further connection is accepted
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		for {
+			con, err := l.Accept()
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					// program canceled, no error
+					return
+				default:
+					custError := "[FAILURE] MAIN: Error while accepting: " + err.Error()
+					log.Panic(custError)
+				}
+			}
+			log.Debug("[SUCCESS] MAIN " + strconv.Itoa(int(thisNode.conf.APIPort)) + ": New Connection established")
+			err = con.SetDeadline(time.Now().Add(time.Minute * 20)) //Set Timeout
+			if err != nil {
+				custError := "[FAILURE] MAIN: Error while setting timeout: " + err.Error()
+				log.Panic(custError)
+			}
+			//for each newly established connection we concurrently call the handleAPIconnection() function, Close waits
+			//until the requests in progress are answered
+			thisNode.wg.Add(1)
+			go func() {
+				defer thisNode.wg.Done()
+				stop := interruptReadsOnCancel(ctx, con)
+				defer stop()
+				thisNode.handleAPIconnection(ctx, con)
+			}()
+		}
+
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("[DEBUG] APIMessageDispatcher received stoppingSignal")
+			l.Close()
+			<-accepting
+			return
+		}
+	}
+}
+
+/*
+readAPIMessage reads exactly one apiMessage from the given reader. It first reads the 4 byte header and then exactly
+size-4 further bytes. Because the reader is consumed message by message, several messages arriving within one
+TCP segment as well as messages split over several segments are handled correctly.
+*/
+func readAPIMessage(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint16(header[:2])
+	if int(size) < len(header) {
+		return nil, errors.New("specified 'size' (" + strconv.Itoa(int(size)) + ") is smaller than the header")
+	}
+
+	messageData := make([]byte, size)
+	copy(messageData, header)
+	_, err = io.ReadFull(reader, messageData[len(header):])
+	if err != nil {
+		return nil, err
+	}
+	return messageData, nil
+}
+
+// checks if a received message is a request the API server accepts and its size is valid for its type before the body
+// gets parsed; answers like dhtSUCCESS, dhtFAILURE and dhtPUT_ANSWER are never sent by clients and are rejected
+func hasValidApiMessageSize(messageData []byte) bool {
+	switch binary.BigEndian.Uint16(messageData[2:4]) {
+	case dhtPUT:
+		return len(messageData) >= 8+SIZE_OF_ID
+	case dhtGET:
+		return len(messageData) == 4+SIZE_OF_ID
+	}
+	return false
+}
+
+//listens on one connection for new messages until the client closes it or ctx is canceled
+func (thisNode *Node) handleAPIconnection(ctx context.Context, con net.Conn) {
+	reader := bufio.NewReader(con)
+	for {
+		//On the connection we read the next message
+		receivedMessageRaw, err := readAPIMessage(reader)
+		if err != nil {
+			custError := "[pot. FAILURE] MAIN: Error while reading from connection: " + err.Error() + " (This might be because no more data was sent)"
+			log.Error(custError)
+			con.Close()
+			return
+		}
+		log.Debug("Received message has size: ", len(receivedMessageRaw))
+
+		if !hasValidApiMessageSize(receivedMessageRaw) {
+			custError := "[FAILURE] MAIN " + strconv.Itoa(int(thisNode.conf.APIPort)) + ": Message size (" + strconv.Itoa(len(receivedMessageRaw)) + ") does not match expected size for its type"
+			log.Error(custError)
+			con.Close()
+			return
+		}
+
+		//out of the received bytes we create an instance of type apiMessage
+		receivedMsg, err := makeApiMessageOutOfBytes(receivedMessageRaw)
+		if err != nil {
+			log.Error("[FAILURE] MAIN: Could not parse message: ", err)
+			con.Close()
+			return
+		}
+		log.Debug("API ", thisNode.conf.APIPort, " Received message : ", receivedMsg.toString())
+
+		switch receivedMsg.header.messageType {
+		case dhtPUT:
+			answer := thisNode.handlePut(receivedMsg.body.(*putBody))
+			//the answerMessage will be of type dhtPutAnswer or dhtFailure
+			answerMessage := makeApiMessageOutOfPutAnswer(answer)
+			_, err := con.Write(answerMessage.data)
+			if err != nil {
+				custError := "[FAILURE] MAIN:  Error while writing to connection: " + err.Error()
+				log.Error(custError)
+				con.Close()
+				return
+			}
+
+		case dhtGET:
+			answer := thisNode.handleGet(receivedMsg.body.(*getBody))
+			//the answerMessage will be of type dhtFailure or dhtSuccess
+			answerMessage := makeApiMessageOutOfAnswer(answer)
+			//we send the answer back
+			_, err := con.Write(answerMessage.data)
+			if err != nil {
+				custError := "[FAILURE] MAIN:  Error while writing to connection: " + err.Error()
+				log.Panic(custError)
+			}
+			log.Debug("[SUCCESS] MAIN: Written answer to connection")
+
+		default:
+			custError := "[FAILURE] MAIN: Message was of not specified type: " + strconv.Itoa(int(receivedMsg.header.messageType))
+			log.Error(custError)
+			con.Close()
+			return
+		}
+
+		err = con.SetDeadline(time.Now().Add(time.Minute * 20)) //Timeout restarted
+		if err != nil {
+			custError := "[FAILURE] MAIN: Error while setting timeout: " + err.Error()
+			log.Panic(custError)
+		}
+		//the node is shutting down, no further requests are accepted (checked after the timeout was restarted, as
+		//interruptReadsOnCancel sets the read deadline once ctx is canceled)
+		if ctx.Err() != nil {
+			con.Close()
+			return
+		}
+	}
+}
+
+/*
+The handleGet function calls the nodeLookup() function according to the Kademlia protocol. nodeLookup() iteratively
+contacts peers it believes to be close to the specified key for which we shall retreive the value and returns the value
+directly as soon as one of them answers with it.
+*/
+func (thisNode *Node) handleGet(body *getBody) DhtAnswer {
+	var value, valueFound = thisNode.Get(body.key)
+
+	// if value found
+	if valueFound {
+		// the value was found. A DHTsuccess message will be sent back
+		return DhtAnswer{
+			success: true,
+			key:     body.key,
+			value:   value,
+		}
+	} else {
+		// the value was not found. A DHTFailure message will be sent back
+		return DhtAnswer{
+			success: false,
+			key:     body.key,
+		}
+
+	}
+}
+
+/*
+The handlePut() function first locates the k closest Nodes in network with the nodeLookup() function.
+Then it sends KDM_STORE messages with the key-value pair to the k closest nodes to the specified key and counts
+how many of them acknowledged the storage. The count is reported back to the client.
+as a chaching mechanism we additionally store the key-value pair locally
+in case it is requested briefly again.
+*/
+func (thisNode *Node) handlePut(body *putBody) putAnswer {
+	log.Debug("handlePut has received :", body.toString())
+	return thisNode.put(body.key, body.value, body.ttl, body.replication)
+}
@@ -1,8 +1,10 @@
-package main
+package dht
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	ran "math/rand"
 	"net"
@@ -10,6 +12,7 @@ import (
 	"reflect"
 	"strconv"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -24,10 +27,9 @@ Note: This function is not meant for testing the P2P communication. We only star
 after initialization as this peer is not able to connect to the bootstraping peers from the configuration file. This is
 intended.
 */
-func helpTestAPICommunication(t *testing.T) {
+func helpTestAPICommunication(t *testing.T, apiAddr string) {
 
 	waitingTime := time.Duration(ran.Intn(1000))
-	apiAddr := Conf.apiIP + ":" + strconv.Itoa(int(Conf.apiPort))
 
 	//First we connect to the apiAddress via tcp
 
@@ -69,9 +71,26 @@ func helpTestAPICommunication(t *testing.T) {
 	}
 	fmt.Println("[TEST] Wrote a dhtPUT message to dht Instance...: ", putMsg.body.(*putBody).value)
 	fmt.Println()
-	//we sleep for a short period to let the DHT do its work
+
+	//the dhtPUT is acknowledged with the number of replicas that confirmed the storage
+	putReply := make([]byte, maxMessageLength)
+	putReplySize, err := conn.Read(putReply)
+	if err != nil {
+		fmt.Println("[TEST] Read from server failed:", err.Error())
+		return
+	}
+	putAnswerMsg, err := makeApiMessageOutOfBytes(putReply[:putReplySize])
+	if err != nil {
+		t.Errorf("[FAILURE] could not parse message: %v", err)
+		return
+	}
+	if putAnswerMsg.header.messageType != dhtPUT_ANSWER {
+		t.Errorf("[FAILURE] We did not receive a dhtPUT_ANSWER")
+	} else if putAnswerMsg.body.(*putAnswerBody).replicas == 0 || putAnswerMsg.body.(*putAnswerBody).key != key {
+		t.Errorf("[FAILURE] dhtPUT_ANSWER does not confirm the storage of the key")
+	}
+	//we sleep for a short period
 	time.Sleep(time.Duration(waitingTime * time.Millisecond))
-	time.Sleep(4 * time.Second)
 
 	/*
 		TEST II: GET message to retreive value.
@@ -97,7 +116,11 @@ func helpTestAPICommunication(t *testing.T) {
 		//os.Exit(1)
 		return
 	}
-	answerMsg := makeApiMessageOutOfBytes(reply[:msgSize])
+	answerMsg, err := makeApiMessageOutOfBytes(reply[:msgSize])
+	if err != nil {
+		t.Errorf("[FAILURE] could not parse message: %v", err)
+		return
+	}
 	fmt.Println()
 	//fmt.Println("[TEST] received this answer: ", answerMsg.toString(), waitingTime)
 
@@ -140,7 +163,11 @@ func helpTestAPICommunication(t *testing.T) {
 		//os.Exit(1)
 		return
 	}
-	answerMsg2 := makeApiMessageOutOfBytes(reply2[:msgSize2])
+	answerMsg2, err := makeApiMessageOutOfBytes(reply2[:msgSize2])
+	if err != nil {
+		t.Errorf("[FAILURE] could not parse message: %v", err)
+		return
+	}
 	fmt.Println()
 	//fmt.Println("[TEST] received this answer: ", answerMsg2.toString())
 	fmt.Println("[TEST] received an answer (2nd): ")
@@ -160,17 +187,108 @@ TestAPICommunciationConcurrency runs multiple (e.g. 1000) instances of the helpT
 to test the ability to handle hundreds or thousands of concurrent api requests
 */
 func TestAPICommunicationConcurrency(t *testing.T) {
-	ctx, cancelFunction := context.WithCancel(context.Background())
-	go mainWithContext(ctx)
-	time.Sleep(10 * time.Second)
+	opts, err := LoadOptions("../config/mainConfig.ini")
+	if err != nil {
+		t.Fatal("[FAILURE] could not load configuration: ", err)
+	}
+	opts.HostKeyFile = "../" + opts.HostKeyFile
+	node, err := NewNode(opts)
+	if err != nil {
+		t.Fatal("[FAILURE] could not create node: ", err)
+	}
+	err = node.Start(context.Background())
+	if err != nil && err != ErrNoBootstrapPeer {
+		t.Fatal("[FAILURE] could not start node: ", err)
+	}
+	apiAddr := opts.APIIP + ":" + strconv.Itoa(int(opts.APIPort))
 	numberOfConcurrentTests := 40
 	for i := 0; i < numberOfConcurrentTests; i++ {
-		go helpTestAPICommunication(t)
+		go helpTestAPICommunication(t, apiAddr)
 	}
 	time.Sleep(30 * time.Second) //we now wait a bit to let the multiple tests run
 	fmt.Println(counter, "out of ", numberOfConcurrentTests*2, " tests did work")
-	cancelFunction()
-	time.Sleep(10 * time.Second) //we now wait a bit to let the goroutines stop
+	node.Close()
 }
 
 var counter int
+
+/*
+TestReadAPIMessage checks that readAPIMessage splits a stream into messages based on the size field of the header.
+Two pipelined messages are delivered one byte at a time, so both partial reads and several messages per stream
+are covered.
+*/
+func TestReadAPIMessage(t *testing.T) {
+	var key id
+	copy(key[:], []byte("0123456789abcdef0123456789abcdef"))
+	getMsg := makeApiMessageOutOfBody(&getBody{key: key}, dhtGET)
+	putMsg := makeApiMessageOutOfBody(&putBody{ttl: 20, replication: 3, key: key, value: []byte("value")}, dhtPUT)
+
+	stream := append(append([]byte{}, putMsg.data...), getMsg.data...)
+	reader := iotest.OneByteReader(bytes.NewReader(stream))
+
+	first, err := readAPIMessage(reader)
+	if err != nil {
+		t.Fatal("[FAILURE] could not read first message: ", err)
+	}
+	if !reflect.DeepEqual(first, putMsg.data) {
+		t.Errorf("[FAILURE] first message was not read correctly")
+	}
+	second, err := readAPIMessage(reader)
+	if err != nil {
+		t.Fatal("[FAILURE] could not read second message: ", err)
+	}
+	if !reflect.DeepEqual(second, getMsg.data) {
+		t.Errorf("[FAILURE] second message was not read correctly")
+	}
+	if _, err = readAPIMessage(reader); err == nil {
+		t.Errorf("[FAILURE] reading from an exhausted stream has to fail")
+	}
+
+	// a size field smaller than the header itself is invalid
+	if _, err = readAPIMessage(bytes.NewReader([]byte{0, 2, 2, 139})); err == nil {
+		t.Errorf("[FAILURE] a message with a size smaller than the header has to be rejected")
+	}
+
+	// a message which is cut off has to be rejected
+	if _, err = readAPIMessage(bytes.NewReader(getMsg.data[:20])); err == nil {
+		t.Errorf("[FAILURE] a truncated message has to be rejected")
+	}
+}
+
+// Test if frames which are too short for their type or are no requests are rejected without being parsed
+func TestShortAPIMessages(t *testing.T) {
+	thisNode := buildTestNode("0", 8119)
+	// a TCP connection is used, as the server restarts the timeout of a net.Pipe closed by the client with an error
+	l, err := net.Listen("tcp", "127.0.0.1:8119")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	defer l.Close()
+	for _, messageType := range []uint16{dhtPUT, dhtGET, dhtSUCCESS, dhtFAILURE, dhtPUT_ANSWER} {
+		for _, size := range []int{4, 4 + SIZE_OF_ID} {
+			frame := make([]byte, size)
+			binary.BigEndian.PutUint16(frame[:2], uint16(size))
+			binary.BigEndian.PutUint16(frame[2:4], messageType)
+
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal("[FAILURE] could not connect: ", err)
+			}
+			server, err := l.Accept()
+			if err != nil {
+				t.Fatal("[FAILURE] could not accept: ", err)
+			}
+			go thisNode.handleAPIconnection(context.Background(), server)
+			client.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.Write(frame); err != nil {
+				t.Fatal("[FAILURE] could not write message: ", err)
+			}
+			answer, err := readAPIMessage(client)
+			client.Close()
+			// only a dhtGET of this size is a valid request
+			if valid := messageType == dhtGET && size == 4+SIZE_OF_ID; valid != (err == nil) {
+				t.Errorf("[FAILURE] frame of type %d and size %d: answer %v, error %v", messageType, size, answer, err)
+			}
+		}
+	}
+}
@@ -1,7 +1,8 @@
-package main
+package dht
 
 import (
 	"encoding/binary"
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"strconv"
 )
@@ -10,6 +11,7 @@ const dhtPUT = 650
 const dhtGET = 651
 const dhtSUCCESS = 652
 const dhtFAILURE = 653
+const dhtPUT_ANSWER = 663
 const maxMessageLength = 65535
 
 /*
@@ -22,6 +24,16 @@ type DhtAnswer struct {
 	value   []byte
 }
 
+/*
+a putAnswer is built from the handlePut() function. replicas is the number of peers (including this peer) that confirmed
+the storage of the key, targets the number of peers the key was sent to.
+*/
+type putAnswer struct {
+	key      id
+	replicas uint8
+	targets  uint8
+}
+
 /*
 all apiMessages exist of a header which has the same format for all. the body is specific and varies from message type
 to message type. the data field stores all bytes of the complete message
@@ -157,10 +169,58 @@ func (b *failureBody) decodeBodyToBytes() []byte {
 	return b.key.toByte()
 }
 
+type putAnswerBody struct {
+	replicas uint8
+	targets  uint8
+	reserved uint16
+	key      id
+}
+
+func (b *putAnswerBody) toString() string {
+	return "[replicas: " + strconv.Itoa(int(b.replicas)) + ", targets: " + strconv.Itoa(int(b.targets)) + "]\n     [Key: " + bytesToString(b.key.toByte()) + "]"
+}
+func (b *putAnswerBody) decodeBodyFromBytes(m *apiMessage) {
+	//decodeBodyFromBytes of putAnswerBody is only needed for testing
+	b.replicas = m.data[4]
+	b.targets = m.data[5]
+	b.reserved = binary.BigEndian.Uint16(m.data[6:8])
+	var key [SIZE_OF_ID]byte
+	copy(key[:], m.data[8:8+SIZE_OF_ID])
+	b.key = key
+}
+func (b *putAnswerBody) decodeBodyToBytes() []byte {
+	result := make([]byte, 4)
+	result[0] = b.replicas
+	result[1] = b.targets
+	binary.BigEndian.PutUint16(result[2:4], b.reserved)
+	result = append(result, b.key.toByte()...)
+	return result
+}
+
+// minimum size of an API message of the given type including the header, 0 for unknown types
+func minimumApiMessageSize(messageType uint16) int {
+	switch messageType {
+	case dhtPUT, dhtPUT_ANSWER:
+		return 8 + SIZE_OF_ID
+	case dhtGET, dhtSUCCESS, dhtFAILURE:
+		return 4 + SIZE_OF_ID
+	}
+	return 0
+}
+
 /*
 makeApiMessageOutOfBytes builds an instance of received bytes of e.g. a dhtGet or a dhtPut message
+returns an error if the message is too short for its type, so the body is never decoded out of missing bytes
 */
-func makeApiMessageOutOfBytes(messageData []byte) apiMessage {
+func makeApiMessageOutOfBytes(messageData []byte) (apiMessage, error) {
+	if len(messageData) < 4 {
+		return apiMessage{}, errors.New("message of size " + strconv.Itoa(len(messageData)) + " is shorter than the header")
+	}
+	messageType := binary.BigEndian.Uint16(messageData[2:4])
+	if len(messageData) < minimumApiMessageSize(messageType) {
+		return apiMessage{}, errors.New("message of size " + strconv.Itoa(len(messageData)) + " is too short for type " + strconv.Itoa(int(messageType)))
+	}
+
 	//extracting header
 	hdr := apiHeader{
 		size:        binary.BigEndian.Uint16(messageData[:2]),
@@ -189,13 +249,16 @@ func makeApiMessageOutOfBytes(messageData []byte) apiMessage {
 	case dhtFAILURE:
 		msg.body = &failureBody{}
 		msg.body.decodeBodyFromBytes(&msg)
+	case dhtPUT_ANSWER:
+		msg.body = &putAnswerBody{}
+		msg.body.decodeBodyFromBytes(&msg)
 
 	default:
 		custError := "[FAILURE] Received Message with unknown Type " + strconv.Itoa(int(msg.header.messageType))
 		log.Error(custError)
 	}
 
-	return msg
+	return msg, nil
 }
 
 /*
@@ -228,3 +291,32 @@ func makeApiMessageOutOfAnswer(answer DhtAnswer) apiMessage {
 	msg.data = data
 	return msg
 }
+
+/*
+makeApiMessageOutOfPutAnswer builds a dhtPUT_ANSWER message out of a putAnswer, reporting how many replicas confirmed
+the storage. If no replica confirmed the storage a dhtFailure message is built instead.
+*/
+func makeApiMessageOutOfPutAnswer(answer putAnswer) apiMessage {
+	msg := apiMessage{}
+	if answer.replicas > 0 {
+		msg.header.messageType = dhtPUT_ANSWER
+		msg.body = &putAnswerBody{
+			replicas: answer.replicas,
+			targets:  answer.targets,
+			key:      answer.key,
+		}
+	} else {
+		msg.header.messageType = dhtFAILURE
+		msg.body = &failureBody{
+			key: answer.key,
+		}
+	}
+	bodyData := msg.body.decodeBodyToBytes()
+	msg.header.size = uint16(2 + 2 + len(bodyData))
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint16(data[:2], msg.header.size)
+	binary.BigEndian.PutUint16(data[2:4], msg.header.messageType)
+	data = append(data, bodyData...)
+	msg.data = data
+	return msg
+}
@@ -1,4 +1,4 @@
-package main
+package dht
 
 import (
 	"crypto/rand"
@@ -46,6 +46,7 @@ func makeApiMessageOutOfBody(msgBody apiBody, msgType uint16) apiMessage {
 }
 
 func TestGetCodingAndDecoding(t *testing.T) {
+	testNode := Node{}
 
 	randomBytesForID := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForID); err != nil {
@@ -53,7 +54,7 @@ func TestGetCodingAndDecoding(t *testing.T) {
 	}
 	var i id
 	copy(i[:], randomBytesForID)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	randomBytesForKey := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForKey); err != nil {
@@ -73,7 +74,10 @@ func TestGetCodingAndDecoding(t *testing.T) {
 	fmt.Println(get1.data)
 
 	//second we create another dhtGet Message out of the byte representation of the first dhtGet message
-	get2 := makeApiMessageOutOfBytes(get1.data)
+	get2, err := makeApiMessageOutOfBytes(get1.data)
+	if err != nil {
+		t.Fatal("[FAILURE] could not parse message: ", err)
+	}
 	fmt.Println("get2: ", get2.toString())
 
 	//third we compare both messages to see if they are the identical
@@ -90,6 +94,7 @@ func TestGetCodingAndDecoding(t *testing.T) {
 }
 
 func TestFailureCodingAndDecoding(t *testing.T) {
+	testNode := Node{}
 
 	randomBytesForID := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForID); err != nil {
@@ -97,7 +102,7 @@ func TestFailureCodingAndDecoding(t *testing.T) {
 	}
 	var i id
 	copy(i[:], randomBytesForID)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	randomBytesForKey := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForKey); err != nil {
@@ -117,7 +122,10 @@ func TestFailureCodingAndDecoding(t *testing.T) {
 
 	fmt.Println(failure1.data)
 	//second we create another dhtFailure Message out of the byte representation of the first dhtFailure message
-	failure2 := makeApiMessageOutOfBytes(failure1.data)
+	failure2, err := makeApiMessageOutOfBytes(failure1.data)
+	if err != nil {
+		t.Fatal("[FAILURE] could not parse message: ", err)
+	}
 	fmt.Println("failure2: ", failure2.toString())
 
 	//third we compare both messages to see if they are the identical
@@ -134,6 +142,7 @@ func TestFailureCodingAndDecoding(t *testing.T) {
 }
 
 func TestSuccessCodingAndDecoding(t *testing.T) {
+	testNode := Node{}
 
 	randomBytesForID := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForID); err != nil {
@@ -141,7 +150,7 @@ func TestSuccessCodingAndDecoding(t *testing.T) {
 	}
 	var i id
 	copy(i[:], randomBytesForID)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	randomBytesForKey := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForKey); err != nil {
@@ -168,7 +177,10 @@ func TestSuccessCodingAndDecoding(t *testing.T) {
 
 	fmt.Println(success1.data)
 	//second we create another dhtSuccess Message out of the byte representation of the first dhtSuccess message
-	success2 := makeApiMessageOutOfBytes(success1.data)
+	success2, err := makeApiMessageOutOfBytes(success1.data)
+	if err != nil {
+		t.Fatal("[FAILURE] could not parse message: ", err)
+	}
 	fmt.Println("success2: ", success2.toString())
 
 	//third we compare both messages to see if they are the identical
@@ -185,6 +197,7 @@ func TestSuccessCodingAndDecoding(t *testing.T) {
 }
 
 func TestPutCodingAndDecoding(t *testing.T) {
+	testNode := Node{}
 
 	randomBytesForID := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForID); err != nil {
@@ -192,7 +205,7 @@ func TestPutCodingAndDecoding(t *testing.T) {
 	}
 	var i id
 	copy(i[:], randomBytesForID)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	randomBytesForKey := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(randomBytesForKey); err != nil {
@@ -223,7 +236,10 @@ func TestPutCodingAndDecoding(t *testing.T) {
 	fmt.Println(put1.data)
 	//second we create another dhtPut Message out of the byte representation of the first dhtPut message
 
-	put2 := makeApiMessageOutOfBytes(put1.data)
+	put2, err := makeApiMessageOutOfBytes(put1.data)
+	if err != nil {
+		t.Fatal("[FAILURE] could not parse message: ", err)
+	}
 	fmt.Println("put2: ", put2.toString())
 
 	//third we compare both messages to see if they are the identical
@@ -237,3 +253,45 @@ func TestPutCodingAndDecoding(t *testing.T) {
 		t.Errorf("[FAILURE] Parsing of Body (put)  does not work")
 	}
 }
+
+func TestPutAnswerCodingAndDecoding(t *testing.T) {
+	randomBytesForKey := make([]byte, SIZE_OF_ID)
+	if _, err := rand.Read(randomBytesForKey); err != nil {
+		panic(err.Error())
+	}
+	var key id
+	copy(key[:], randomBytesForKey)
+
+	//a confirmed storage is answered with a dhtPUT_ANSWER
+	answer1 := makeApiMessageOutOfPutAnswer(putAnswer{key: key, replicas: 3, targets: 5})
+	answer2, err := makeApiMessageOutOfBytes(answer1.data)
+	if err != nil {
+		t.Fatal("[FAILURE] could not parse message: ", err)
+	}
+	if int(answer1.header.size) != len(answer1.data) {
+		t.Errorf("[FAILURE] Header size does not match the length of the message")
+	}
+	if answer2.header.messageType != dhtPUT_ANSWER {
+		t.Errorf("[FAILURE] Parsing of Header messageType does not work")
+	}
+	if !reflect.DeepEqual(answer1.body, answer2.body) {
+		t.Errorf("[FAILURE] Parsing of Body (put answer) does not work")
+	}
+
+	//a storage without any confirmation is answered with a dhtFAILURE
+	failure, err := makeApiMessageOutOfBytes(makeApiMessageOutOfPutAnswer(putAnswer{key: key, targets: 5}).data)
+	if err != nil {
+		t.Fatal("[FAILURE] could not parse message: ", err)
+	}
+	if failure.header.messageType != dhtFAILURE {
+		t.Errorf("[FAILURE] Storage without any replica has to be answered with dhtFAILURE")
+	}
+	if failure.body.(*failureBody).key != key {
+		t.Errorf("[FAILURE] dhtFAILURE has to contain the key")
+	}
+
+	//a truncated dhtPUT_ANSWER is rejected instead of being decoded out of missing bytes
+	if _, err = makeApiMessageOutOfBytes(answer1.data[:8]); err == nil {
+		t.Errorf("[FAILURE] truncated dhtPUT_ANSWER has to be rejected")
+	}
+}
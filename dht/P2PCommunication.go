@@ -0,0 +1,860 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// struct which represents the data storage
+// the <key, value>-pairs and their expiration and republishing times are kept by a storage backend (see storage.go)
+type hashTable struct {
+	storage storage
+}
+
+// reads value for given key from the local data storage
+// returns the value or nil and a boolean if the value was found
+func (hashTable *hashTable) read(key id) ([]byte, bool) {
+	entry, existing := hashTable.storage.read(key)
+	return entry.value, existing
+}
+
+// writes <key, value>-pair to the local data storage
+func (hashTable *hashTable) write(key id, value []byte, expiration time.Time, republishingTime time.Time, replication uint8) {
+	err := hashTable.storage.write(key, storageEntry{value: value, expiration: expiration, republishingTime: republishingTime, replication: replication})
+	if err != nil {
+		log.Error("[FAILURE] Could not write key ", key[:10], ": ", err)
+		return
+	}
+	log.Debug("WE HAVE WRITTEN KEY_VALUE PAIR: ", key[:10], "  - ", value, " (ttl ", expiration, ")")
+}
+
+// writes a <key, value>-pair cached on a lookup path to the local data storage, unless the local node already holds a
+// replica of it. Cached pairs are never due for republishing and are not replicated or handed over.
+func (hashTable *hashTable) writeCached(key id, value []byte, expiration time.Time) {
+	if entry, existing := hashTable.storage.read(key); existing && !entry.cached {
+		return
+	}
+	err := hashTable.storage.write(key, storageEntry{value: value, expiration: expiration, republishingTime: expiration, cached: true})
+	if err != nil {
+		log.Error("[FAILURE] Could not cache key ", key[:10], ": ", err)
+		return
+	}
+	log.Debug("Cached key ", key[:10], " (ttl ", expiration, ")")
+}
+
+// maximum number of <key, value>-pairs republished at the same time
+const PARALLEL_REPUBLISHES = 8
+
+// pairs which were taken for republishing, the zero value is ready to use
+type republishQueue struct {
+	// pairs waiting for a worker and keys of the pairs which are republished right now
+	pending map[id]storageEntry
+	running map[id]bool
+	workers int
+	sync.Mutex
+}
+
+/*
+republishKeys takes the stored <key, value>-pairs which are due for republishing and resets their republishing time
+to REPUBLISH_TIME seconds from now, so every pair is handed out once per interval. The pairs are queued and republished
+in the background by at most PARALLEL_REPUBLISHES workers of the node, so the timers are not blocked by the lookups and
+the number of lookups stays bounded even if the republishing takes longer than the interval of the timers. A pair whose
+republishing is still running is skipped.
+Pairs received with a KDM_STORE get a new republishing time as well, so a pair another peer republished within the
+interval is not republished again (see section 2.5 of the Kademlia paper).
+*/
+func (hashTable *hashTable) republishKeys(thisNode *Node) {
+	now := time.Now()
+	due := hashTable.storage.takeDueForRepublishing(now, now.Add(time.Duration(REPUBLISH_TIME)*time.Second))
+	if len(due) == 0 {
+		return
+	}
+	queue := &thisNode.republishQueue
+	queue.Lock()
+	defer queue.Unlock()
+	if queue.pending == nil {
+		queue.pending = make(map[id]storageEntry)
+		queue.running = make(map[id]bool)
+	}
+	for key, entry := range due {
+		if queue.running[key] {
+			log.Debug("Skipping republishing of ", fmt.Sprint(key), ", it is still running")
+			continue
+		}
+		// a pair still waiting is replaced, as its republishing time was reset again
+		queue.pending[key] = entry
+	}
+	for queue.workers < PARALLEL_REPUBLISHES && queue.workers < len(queue.pending) {
+		queue.workers++
+		thisNode.wg.Add(1)
+		go hashTable.runRepublishing(thisNode)
+	}
+}
+
+// republishes the queued pairs one after another until the queue is empty
+func (hashTable *hashTable) runRepublishing(thisNode *Node) {
+	defer thisNode.wg.Done()
+	queue := &thisNode.republishQueue
+	for {
+		queue.Lock()
+		if len(queue.pending) == 0 {
+			queue.workers--
+			queue.Unlock()
+			return
+		}
+		var key id
+		var taken storageEntry
+		for key, taken = range queue.pending {
+			break
+		}
+		delete(queue.pending, key)
+		queue.running[key] = true
+		queue.Unlock()
+
+		hashTable.republish(thisNode, key, taken)
+
+		queue.Lock()
+		delete(queue.running, key)
+		queue.Unlock()
+	}
+}
+
+// republishes the pair of key with its remaining TTL, unless it was removed or received again with a KDM_STORE after
+// it was taken for republishing
+func (hashTable *hashTable) republish(thisNode *Node, key id, taken storageEntry) {
+	entry, existing := hashTable.storage.read(key)
+	if !existing || entry.cached || !entry.republishingTime.Equal(taken.republishingTime) {
+		log.Debug("Skipping republishing of ", fmt.Sprint(key), ", it was stored again in the meantime")
+		return
+	}
+	ttl, ok := entry.remainingTTL(time.Now())
+	if !ok {
+		return
+	}
+	log.Debug("Republishing: " + fmt.Sprint(key))
+	thisNode.store(key, entry.value, ttl, entry.replication)
+}
+
+// maximum number of <key, value>-pairs handOverKeys pushes to the network at the same time
+const PARALLEL_HANDOFFS = 8
+
+/*
+handOverKeys is called when the node shuts down. It pushes every stored <key, value>-pair which is not expired yet to
+the closest remaining peers (as many as its replication degree demands), together with its remaining TTL, so the pairs
+are not lost with the node. Pairs cached on a lookup path are dropped. Returns the number of pairs at least one peer
+acknowledged.
+*/
+func (hashTable *hashTable) handOverKeys(thisNode *Node) int {
+	now := time.Now()
+	semaphore := make(chan struct{}, PARALLEL_HANDOFFS)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	total, handedOver := 0, 0
+	for key, entry := range hashTable.storage.readAll() {
+		ttl, ok := entry.remainingTTL(now)
+		if !ok || entry.cached {
+			continue
+		}
+		total++
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(key id, entry storageEntry, ttl uint16) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			// locate the closest remaining peers like store, the local node does not count as replica anymore
+			degree := thisNode.replicationDegree(entry.replication)
+			number := degree
+			if number < thisNode.conf.K {
+				number = thisNode.conf.K
+			}
+			closestPeers := thisNode.nodeLookup(key, false, number).closestPeers
+			if len(closestPeers) > degree {
+				closestPeers = closestPeers[:degree]
+			}
+			if thisNode.storeOnPeers(key, entry.value, ttl, entry.replication, closestPeers) > 0 {
+				lock.Lock()
+				handedOver++
+				lock.Unlock()
+			}
+		}(key, entry, ttl)
+	}
+	wg.Wait()
+
+	if handedOver < total {
+		log.Error("[FAILURE] Could only hand over ", handedOver, " of ", total, " keys")
+	} else {
+		log.Info("[SUCCESS] Handed over ", handedOver, " keys")
+	}
+	return handedOver
+}
+
+// newcomers whose replication is pending, handled by a single worker; the zero value is ready to use
+type replicationQueue struct {
+	newcomers map[id]peer
+	running   bool
+	sync.Mutex
+}
+
+/*
+scheduleReplication is called when newcomer enters the routing table. The replication of the stored pairs to newcomer
+is queued and done by a single worker, which takes all newcomers queued in the meantime and handles them with one pass
+over the storage. A join or restore which inserts many peers at once therefore does not start a goroutine reading all
+pairs per insertion. The worker is tracked by the wait group of the node and is not started once the node is closing,
+so no KDM_STORE is sent after Close returned.
+*/
+func (thisNode *Node) scheduleReplication(newcomer peer) {
+	queue := &thisNode.replicationQueue
+	queue.Lock()
+	defer queue.Unlock()
+	if queue.newcomers == nil {
+		queue.newcomers = make(map[id]peer)
+	}
+	queue.newcomers[newcomer.id] = newcomer
+	if !queue.running && !thisNode.closing() {
+		queue.running = true
+		thisNode.wg.Add(1)
+		go thisNode.runReplication()
+	}
+}
+
+// replicates the stored pairs to the queued newcomers until the queue is empty or the node is closing
+func (thisNode *Node) runReplication() {
+	defer thisNode.wg.Done()
+	queue := &thisNode.replicationQueue
+	for {
+		queue.Lock()
+		newcomers := queue.newcomers
+		queue.newcomers = nil
+		if len(newcomers) == 0 || thisNode.closing() {
+			queue.running = false
+			queue.Unlock()
+			return
+		}
+		queue.Unlock()
+		thisNode.replicateKeysTo(newcomers)
+	}
+}
+
+/*
+replicateKeysTo transfers the stored <key, value>-pairs to the given newcomers. Following section 2.5 of the Kademlia
+paper, a pair is transferred to a newcomer if it is now among the closest known peers of the key (as many as its
+replication degree demands, including the local node) and no known peer except the newcomer is closer to the key than
+the local node, so only the closest previous holder sends it instead of all of them. The pairs are transferred with
+their remaining TTL, so they are reachable at the newcomer right away instead of only after the next republishing.
+Pairs cached on a lookup path are not transferred. The transfer to a newcomer stops as soon as it does not acknowledge
+a pair, the whole transfer as soon as the node is closing.
+*/
+func (thisNode *Node) replicateKeysTo(newcomers map[id]peer) {
+	transferred := make(map[id]int)
+	stopped := make(map[id]bool)
+	for key, entry := range thisNode.hashTable.storage.readAll() {
+		if thisNode.closing() {
+			return
+		}
+		ttl, ok := entry.remainingTTL(time.Now())
+		if !ok || entry.cached || !thisNode.isClosestHolder(key, newcomers) {
+			continue
+		}
+		degree := thisNode.replicationDegree(entry.replication)
+		for _, newcomer := range newcomers {
+			if stopped[newcomer.id] || !thisNode.isAmongReplicas(key, newcomer, degree) {
+				continue
+			}
+			if thisNode.storeOnPeers(key, entry.value, ttl, entry.replication, []peer{newcomer}) == 0 {
+				log.Error("[FAILURE] Stopped replicating keys to ", newcomer.toString())
+				stopped[newcomer.id] = true
+				continue
+			}
+			transferred[newcomer.id]++
+		}
+	}
+	for _, newcomer := range newcomers {
+		if transferred[newcomer.id] > 0 {
+			log.Debug("Replicated ", transferred[newcomer.id], " keys to ", newcomer.toString())
+		}
+	}
+}
+
+// checks if the local node is closer to key than every known peer except the newcomers, which do not hold it yet
+func (thisNode *Node) isClosestHolder(key id, newcomers map[id]peer) bool {
+	dSelf := distance(key, thisNode.thisPeer.id)
+	// the closest known peer which is not a newcomer is among the len(newcomers)+1 closest ones
+	for _, closestPeer := range thisNode.findNumberOfClosestPeersOnNode(key, len(newcomers)+1) {
+		d := distance(key, closestPeer.id)
+		if _, ok := newcomers[closestPeer.id]; !ok && bytes.Compare(d[:], dSelf[:]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checks if p is among the given number of peers closest to key that the local node knows, including itself
+func (thisNode *Node) isAmongReplicas(key id, p peer, number int) bool {
+	dPeer := distance(key, p.id)
+	closer := 0 // peers closer to key than p
+	found := false
+	for _, closestPeer := range thisNode.findNumberOfClosestPeersOnNode(key, number) {
+		d := distance(key, closestPeer.id)
+		if closestPeer.id == p.id {
+			found = true
+		} else if bytes.Compare(d[:], dPeer[:]) < 0 {
+			closer++
+		}
+	}
+	dSelf := distance(key, thisNode.thisPeer.id)
+	if bytes.Compare(dSelf[:], dPeer[:]) < 0 {
+		closer++
+	}
+	return found && closer < number
+}
+
+// removes all key/value-pairs which are expired
+func (hashTable *hashTable) expireKeys() {
+	err := hashTable.storage.expire(time.Now())
+	if err != nil {
+		log.Error("[FAILURE] Could not remove expired keys: ", err)
+	}
+}
+
+// struct which represents a peer in the network as triple of <ip, port, id>
+type peer struct {
+	ip   string
+	port uint16
+	id   id
+}
+
+func (p *peer) toString() string {
+	return "" + p.ip + ":" + strconv.Itoa(int(p.port)) + " (" + bytesToString(p.id.toByte()[:10]) + ")"
+}
+
+// type which represents the id of a peer
+type id [SIZE_OF_ID]byte
+
+// helper function for checking if id starts with given prefix
+func (id *id) startsWith(prefix string) bool {
+	idString := ""
+	for _, byte := range id {
+		idString += fmt.Sprintf("%08b", byte)
+	}
+	return strings.HasPrefix(idString, prefix)
+}
+
+// converts id to byte slice
+func (id id) toByte() []byte {
+	var result []byte
+	for i := 0; i < SIZE_OF_ID; i++ {
+		result = append(result, id[i])
+	}
+	return result
+}
+
+/*
+readHostKey reads the private host key from the given PEM file, either an RSA key in PKCS #1 encoding ("RSA PRIVATE
+KEY") or an RSA or Ed25519 key in PKCS #8 encoding ("PRIVATE KEY"). It returns the key together with its public key in
+PKIX, DER encoding, whose SHA-256 hash is the ID of the node.
+*/
+func readHostKey(hostKeyFile string) (crypto.Signer, []byte, error) {
+	//first we read the private key
+	priv, err := ioutil.ReadFile(hostKeyFile)
+	if err != nil {
+		return nil, nil, errors.New("Error while reading File: " + err.Error())
+	}
+	block, _ := pem.Decode([]byte(priv))
+	if block == nil || (block.Type != "RSA PRIVATE KEY" && block.Type != "PRIVATE KEY") {
+		return nil, nil, errors.New("failed to decode PEM block containing private key")
+	}
+	var key crypto.Signer
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		var parsed interface{}
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		switch parsed := parsed.(type) {
+		case *rsa.PrivateKey:
+			key = parsed
+		case ed25519.PrivateKey:
+			key = parsed
+		default:
+			if err == nil {
+				err = errors.New("host key has an unsupported type")
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//now we generate the corresponding public key
+	publicKeyDer, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Debug("   Public Key as bytes: ", publicKeyDer[:10], "...")
+	newID := idOfPublicKey(publicKeyDer)
+	log.Info("[SUCCESSS] Our generated ID: ", newID[:])
+	return key, publicKeyDer, nil
+}
+
+// ErrNoBootstrapPeer is returned by Start if the node could not join an existing network, the node runs nevertheless as
+// the first peer of a new network
+var ErrNoBootstrapPeer = errors.New("none of the bootstrap peers answered")
+
+/*
+initializeP2PCommunication joins the network. It re-validates the peers of the routing table snapshot written at the
+last shutdown (see restoreRoutingTable) and contacts the bootstrap peers (see bootstrapPeers), looks up the own id
+to learn about its closest peers and then refreshes all k-Buckets farther away than the closest neighbor, so the routing
+table is populated before the node serves API requests. If neither a peer of the snapshot nor a bootstrap peer answers,
+ErrNoBootstrapPeer is returned and the node starts as the first peer of a new network.
+*/
+func (thisNode *Node) initializeP2PCommunication() error {
+	// peers of the routing table snapshot which still answer are kept
+	answered := thisNode.restoreRoutingTable()
+
+	// the ID of a bootstrap peer is only known from its KDM_PONG
+	bootstrapPeers := thisNode.bootstrapPeers()
+	for i, answer := range thisNode.pingPeers(bootstrapPeers) {
+		if answer != nil {
+			thisNode.updateRoutingTable(answeringPeer(answer, bootstrapPeers[i]))
+			answered++
+		}
+	}
+	if answered == 0 {
+		return ErrNoBootstrapPeer
+	}
+
+	// self-lookup, every answering peer is inserted into the routing table
+	thisNode.nodeLookup(thisNode.thisPeer.id, false, thisNode.conf.K)
+
+	// refresh all k-Buckets farther away than the closest neighbor
+	closestNeighbor := thisNode.findNumberOfClosestPeersOnNode(thisNode.thisPeer.id, 1)
+	if len(closestNeighbor) == 1 {
+		thisNode.refreshBuckets(thisNode.prefixesFartherThan(closestNeighbor[0].id))
+	}
+
+	log.Info("[SUCCESS] FINISHED INITIALIZING OF P2P COMMUNICATION\n")
+	log.Debug(thisNode.thisPeer.port, "stores: ", thisNode.routingTableToString())
+	return nil
+}
+
+/*
+This function can be used to create a peer from the ip:port format and considers both IPv4 and IPv6 formats as specified
+in the specification. The peer that is returned does not yet include an ID
+*/
+func extractPeerAddressFromString(line string) (peer, error) {
+	ip, port, err := net.SplitHostPort(strings.TrimSpace(line))
+	if err != nil {
+		return peer{}, errors.New("Wrong address " + line + ": " + err.Error())
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return peer{}, errors.New("Wrong address " + line + ": the port is not an Integer")
+	}
+	return peer{ip: ip, port: uint16(portNumber)}, nil
+}
+
+// starts the message dispatcher for P2P-Communication
+// listens for connections and delegates them to handleP2PConnection
+func (thisNode *Node) startP2PMessageDispatcher(ctx context.Context) {
+	defer thisNode.wg.Done()
+
+	l := thisNode.p2pListener
+	defer l.Close()
+	log.Info("[SUCCESS] MAIN: P2PMessageDispatcher Listening on ", l.Addr())
+
+	// closed when no further connection is accepted
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		for {
+			// accept connections
+			conn, err := l.Accept()
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					// program canceled, no error
+					return
+				default:
+					custError := "[FAILURE] MAIN: Error while accepting: " + err.Error()
+					log.Panic(custError)
+				}
+			}
+			log.Debug("[SUCCESS] MAIN: New Connection established, ", conn.LocalAddr(), " r:", conn.RemoteAddr())
+
+			// set timeout
+			err = conn.SetDeadline(time.Now().Add(time.Minute * 20))
+			if err != nil {
+				custError := "[FAILURE] MAIN: Error while setting Deadline: " + err.Error()
+				log.Panic(custError)
+			}
+
+			// delegate handling of incoming connection, Close waits until it is handled
+			thisNode.wg.Add(1)
+			go func() {
+				defer thisNode.wg.Done()
+				stop := interruptReadsOnCancel(ctx, conn)
+				defer stop()
+				thisNode.handleP2PConnection(conn)
+			}()
+
+		}
+	}()
+
+	// listen for program to stop
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("[DEBUG] P2PMessageDispatcher received stoppingSignal")
+			l.Close()
+			<-accepting
+			return
+		}
+	}
+
+}
+
+/*
+interruptReadsOnCancel lets blocking reads on conn fail as soon as ctx is canceled, so connection handlers waiting for
+a message return during shutdown, while writes of answers to requests already read still succeed. The returned function
+stops watching ctx and has to be called when the connection is handled.
+*/
+func interruptReadsOnCancel(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// handles incoming connection based on message type
+func (thisNode *Node) handleP2PConnection(conn net.Conn) {
+
+	defer conn.Close()
+
+	m, err := readMessage(conn)
+	if err != nil {
+		custError := "[FAILURE] MAIN: Error while reading from connection: " + err.Error()
+		log.Error(custError)
+		return
+	}
+	if m != nil {
+		bdyStrg := ""
+		if m.body != nil {
+			bdyStrg = m.body.toString()
+		}
+		log.Info(thisNode.thisPeer.ip, ":", thisNode.thisPeer.port, " has received this message: ", m.header.toString(), " : ", bdyStrg)
+
+		// messages which are not signed by the owner of the sender id are dropped before they are processed, on TLS
+		// connections the sender also has to be the peer which authenticated in the handshake; messages of senders
+		// which do not solve the crypto puzzles and replayed and stale messages are dropped as well
+		err = verifyMessage(m)
+		if err == nil {
+			err = verifyConnectionIdentity(conn, m.header.senderPeer.id)
+		}
+		if err == nil {
+			err = thisNode.verifyPuzzles(m)
+		}
+		if err == nil {
+			err = thisNode.replayCache.check(m, time.Now())
+		}
+		if err != nil {
+			log.Error("[FAILURE] Dropped message of type ", m.header.messageType, " from ", m.header.senderPeer.toString(), ": ", err)
+			return
+		}
+
+		// answers are only accepted for requests we are still waiting for
+		if isAnswer(m.header.messageType) {
+			if !thisNode.pendingRequests.resolve(m) {
+				log.Error("[FAILURE] Rejected answer of type ", m.header.messageType, " from ", m.header.senderPeer.toString(), ": ", errUnsolicitedAnswer)
+			}
+			return
+		}
+
+		// update routing table, if the address of the sender can be verified
+		thisNode.learnSender(conn, m.header.senderPeer)
+
+		// switch according to message type
+		switch m.header.messageType {
+		case KDM_PING:
+			// respond with KDM_PONG on the same connection
+			pongMessage := thisNode.makeP2PAnswerOutOfBody(nil, KDM_PONG, m)
+			err := writeMessage(conn, pongMessage)
+			if err != nil {
+				return
+			}
+		case KDM_STORE:
+			// write <key, value>-pair to hashTable
+			ttl := int(m.body.(*kdmStoreBody).ttl)
+			if ttl > thisNode.conf.MaxTTL {
+				ttl = thisNode.conf.MaxTTL
+			}
+			if m.body.(*kdmStoreBody).flags&STORE_FLAG_CACHED != 0 {
+				thisNode.hashTable.writeCached(m.body.(*kdmStoreBody).key, m.body.(*kdmStoreBody).value, time.Now().Add(time.Duration(ttl)*time.Second))
+			} else {
+				thisNode.hashTable.write(m.body.(*kdmStoreBody).key, m.body.(*kdmStoreBody).value, time.Now().Add(time.Duration(ttl)*time.Second), time.Now().Add(time.Duration(REPUBLISH_TIME)*time.Second), m.body.(*kdmStoreBody).replication)
+			}
+
+			// confirm the storage on the same connection
+			answerBody := kdmStoreAckBody{key: m.body.(*kdmStoreBody).key}
+			answer := thisNode.makeP2PAnswerOutOfBody(&answerBody, KDM_STORE_ACK, m)
+			err := writeMessage(conn, answer)
+			if err != nil {
+				log.Error("[FAILURE] Writing KDM_STORE_ACK failed: ", err)
+			}
+			return
+
+		case KDM_FIND_NODE:
+			key := m.body.(*kdmFindNodeBody).id
+
+			// find k closest nodes to given id on local node and return them to sender on the same connection
+			answerBody := thisNode.FIND_NODE(key)
+			answer := thisNode.makeP2PAnswerOutOfBody(&answerBody, KDM_FIND_NODE_ANSWER, m)
+			err := writeMessage(conn, answer)
+			if err != nil {
+				log.Error("[FAILURE] Writing KDM_FIND_NODE_ANSWER failed: ", err)
+			}
+			return
+
+		case KDM_FIND_VALUE:
+			key := m.body.(*kdmFindValueBody).id
+
+			// look for value to given key in local hashTable
+			var value, existing = thisNode.hashTable.read(key)
+			var answer p2pMessage
+			if existing {
+				// reply with value
+				answerBody := kdmFoundValueBody{value: value, key: key}
+				answer = thisNode.makeP2PAnswerOutOfBody(&answerBody, KDM_FOUND_VALUE, m)
+			} else {
+				// same behavior as KDM_FIND_NODE
+				answerBody := thisNode.FIND_NODE(key)
+				answer = thisNode.makeP2PAnswerOutOfBody(&answerBody, KDM_FIND_NODE_ANSWER, m)
+			}
+			err := writeMessage(conn, answer)
+			if err != nil {
+				log.Error("[FAILURE] Writing answer to KDM_FIND_VALUE failed: ", err)
+			}
+			return
+		}
+	}
+
+}
+
+// distance function of kademlia
+func distance(id1 id, id2 id) id {
+
+	xor := id{}
+
+	for i := 0; i < len(id1); i++ {
+		xor[i] = id1[i] ^ id2[i]
+
+	}
+	return xor
+}
+
+// probes a node to check if it is online
+func (thisNode *Node) pingNode(receiverPeer peer) bool {
+	pingMessage := thisNode.makeP2PMessageOutOfBody(nil, KDM_PING)
+
+	// receive KDM_PONG
+	answer, err := thisNode.sendRequest(pingMessage, receiverPeer)
+	if err != nil {
+		custError := "[FAILURE] Ping of " + receiverPeer.toString() + " failed: " + err.Error()
+		log.Error(custError)
+		return false
+	}
+
+	return answer.header.messageType == KDM_PONG
+
+}
+
+// maximum number of KDM_PING requests pingPeers sends at the same time
+const PARALLEL_PINGS = 16
+
+// pings the given peers in parallel and returns their KDM_PONGs in the same order, nil for peers which did not answer
+func (thisNode *Node) pingPeers(peers []peer) []*p2pMessage {
+	answers := make([]*p2pMessage, len(peers))
+	semaphore := make(chan struct{}, PARALLEL_PINGS)
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, p peer) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			pingMessage := thisNode.makeP2PMessageOutOfBody(nil, KDM_PING)
+			answer, err := thisNode.sendRequest(pingMessage, p)
+			if err != nil {
+				log.Error("[FAILURE] Ping of ", p.toString(), " failed: ", err)
+				return
+			}
+			answers[i] = answer
+		}(i, p)
+	}
+	wg.Wait()
+	return answers
+}
+
+// finds k closest nodes to given key on local node and generates body of KDM_FIND_NODE_ANSWER message
+func (thisNode *Node) FIND_NODE(key id) kdmFindNodeAnswerBody {
+
+	closestPeers := thisNode.findNumberOfClosestPeersOnNode(key, thisNode.conf.K)
+	answerBody := kdmFindNodeAnswerBody{answerPeers: closestPeers}
+	log.Debug(answerBody)
+	return answerBody
+}
+
+// returns the number of replicas for a requested replication degree, bounded by the configured minimum and maximum
+// a requested degree of 0 means that the client did not specify one, then k replicas are used
+func (thisNode *Node) replicationDegree(replication uint8) int {
+	degree := int(replication)
+	if degree == 0 {
+		degree = thisNode.conf.K
+	}
+	if degree < thisNode.conf.MinReplication {
+		degree = thisNode.conf.MinReplication
+	}
+	if degree > thisNode.conf.MaxReplication {
+		degree = thisNode.conf.MaxReplication
+	}
+	return degree
+}
+
+/*
+store locates the closest Nodes in the network and sends KDM_STORE messages to as many of them as the replication
+degree demands. It returns how many of them confirmed the storage with a KDM_STORE_ACK and to how many peers the key was
+sent. If the local node itself belongs to the closest nodes it takes one of the replicas and is counted as well, as the
+caller keeps the key in the local hashTable. The requested replication is forwarded, so that the storing peers
+republish with the same degree.
+*/
+func (thisNode *Node) store(key id, value []byte, ttl uint16, replication uint8) (int, int) {
+	degree := thisNode.replicationDegree(replication)
+
+	// locate closest nodes in network, at least k of them to get an accurate result
+	number := degree
+	if number < thisNode.conf.K {
+		number = thisNode.conf.K
+	}
+	kClosestPeers := thisNode.nodeLookup(key, false, number).closestPeers
+	if len(kClosestPeers) > degree {
+		kClosestPeers = kClosestPeers[:degree]
+	}
+	// the local node replaces the farest of the closest peers, the peers are ordered by distance
+	amongClosest := thisNode.isAmongClosest(key, kClosestPeers, degree)
+	if amongClosest && len(kClosestPeers) >= degree {
+		kClosestPeers = kClosestPeers[:degree-1]
+	}
+	log.Debug("FINAL : number of k CLOSEST PEERS", len(kClosestPeers))
+
+	confirmed := thisNode.storeOnPeers(key, value, ttl, replication, kClosestPeers)
+	targets := len(kClosestPeers)
+	if amongClosest {
+		confirmed++
+		targets++
+	}
+	return confirmed, targets
+}
+
+// sends KDM_STORE messages to each of the given peers and returns how many of them acknowledged the storage
+func (thisNode *Node) storeOnPeers(key id, value []byte, ttl uint16, replication uint8, kClosestPeers []peer) int {
+	var wg sync.WaitGroup
+	acknowledgments := make(chan bool, len(kClosestPeers))
+	for _, p := range kClosestPeers {
+		storeBdy := kdmStoreBody{
+			key:         key,
+			value:       value,
+			ttl:         ttl,
+			replication: replication,
+		}
+		m := thisNode.makeP2PMessageOutOfBody(&storeBdy, KDM_STORE)
+		wg.Add(1)
+		go func(m p2pMessage, p peer) {
+			defer wg.Done()
+			answer, err := thisNode.sendRequest(m, p)
+			if err != nil {
+				log.Error("[FAILURE] ", p.toString(), " did not acknowledge KDM_STORE: ", err)
+				acknowledgments <- false
+				return
+			}
+			acknowledgments <- answer.body.(*kdmStoreAckBody).key == key
+		}(m, p)
+	}
+	wg.Wait()
+	close(acknowledgments)
+
+	confirmed := 0
+	for acknowledged := range acknowledgments {
+		if acknowledged {
+			confirmed++
+		}
+	}
+	return confirmed
+}
+
+// checks if the local node is closer to key than the farest of the given number closest peers
+func (thisNode *Node) isAmongClosest(key id, kClosestPeers []peer, number int) bool {
+	if len(kClosestPeers) < number {
+		return true
+	}
+	dSelf := distance(key, thisNode.thisPeer.id)
+	dFarest := distance(key, kClosestPeers[findIndexOfFarestPeerInSlice(kClosestPeers, key)].id)
+	return bytes.Compare(dSelf[:], dFarest[:]) < 0
+}
+
+// checks every second if keys are expired or should be republished
+func (thisNode *Node) startTimers(ctx context.Context) {
+	defer thisNode.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	// the routing table is only snapshotted if a file is configured
+	var snapshots <-chan time.Time
+	if thisNode.conf.RoutingTableFile != "" {
+		snapshotTicker := time.NewTicker(time.Duration(SNAPSHOT_INTERVAL) * time.Second)
+		defer snapshotTicker.Stop()
+		snapshots = snapshotTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug("[DEBUG] Timer received stoppingSignal")
+			return
+		case <-snapshots:
+			err := thisNode.writeRoutingTableSnapshot()
+			if err != nil {
+				log.Error("[FAILURE] ", err)
+			}
+		case <-ticker.C:
+			// expire keys
+			thisNode.hashTable.expireKeys()
+
+			// republish keys
+			thisNode.hashTable.republishKeys(thisNode)
+
+			// refresh k-Buckets without lookup during the refresh interval
+			if prefixes := thisNode.staleBuckets(); len(prefixes) > 0 {
+				thisNode.wg.Add(1)
+				go func() {
+					defer thisNode.wg.Done()
+					thisNode.refreshBuckets(prefixes)
+				}()
+			}
+		}
+	}
+}
@@ -0,0 +1,638 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	id1 := id{}
+	id2 := id{}
+
+	if distance(id1, id2) != id1 {
+		t.Errorf("Distance of two empty ids has to be empty id")
+	}
+}
+
+func TestToByte(t *testing.T) {
+	// prepare random id
+	randByteArray := make([]byte, SIZE_OF_ID)
+	_, err := rand.Read(randByteArray)
+	if err != nil {
+		t.Errorf("Could not generate random id")
+	}
+	var randId id
+	for i := 0; i < SIZE_OF_ID; i++ {
+		randId[i] = randByteArray[i]
+	}
+
+	// test toByte function
+	convertedArray := randId.toByte()
+	for i := 0; i < SIZE_OF_ID; i++ {
+		if convertedArray[i] != randByteArray[i] {
+			t.Errorf("id.toByte() does not return correct byte array")
+			break
+		}
+	}
+}
+
+// Test if pingNode successfully sends a PING request
+func TestPingNode(t *testing.T) {
+	testNode := buildTestNode("", 0)
+
+	if testNode.pingNode(testNode.thisPeer) != false {
+		t.Errorf("Ping of unavailable Node has to be false")
+	}
+
+	// Listen for Ping request
+	ln, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		t.Errorf("Error while listening for tcp connection")
+	}
+
+	testNode.thisPeer.ip = "127.0.0.1"
+	testNode.thisPeer.port = 8080
+
+	// send Ping request
+	go testNode.pingNode(testNode.thisPeer)
+
+	_, err = ln.Accept()
+	if err != nil {
+		t.Errorf("Error while listening for PING")
+	}
+	// else: no error --> PING successfully received
+
+}
+
+// Test if answers are sent back on the same connection and carry the nonce of the request
+func TestAnswerOnSameConnection(t *testing.T) {
+	receiver := buildTestNode("1", 3333)
+	sender := buildTestNode("01", 4444)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go receiver.handleP2PConnection(server)
+
+	request := sender.makeP2PMessageOutOfBody(&kdmFindNodeBody{id: buildTestIdFromString("011")}, KDM_FIND_NODE)
+	if err := writeMessage(client, request); err != nil {
+		t.Fatal("Error while writing KDM_FIND_NODE: ", err)
+	}
+	answer, err := readMessage(client)
+	if err != nil {
+		t.Fatal("No answer received on the same connection: ", err)
+	}
+	if answer.header.messageType != KDM_FIND_NODE_ANSWER {
+		t.Errorf("Answer to KDM_FIND_NODE has to be KDM_FIND_NODE_ANSWER")
+	}
+	if !bytes.Equal(answer.header.nonce, request.header.nonce) {
+		t.Errorf("Answer has to carry the nonce of the request")
+	}
+}
+
+// Test if answers are only accepted for open requests of a fitting type
+func TestPendingRequests(t *testing.T) {
+	testNode := buildTestNode("", 0)
+	request := testNode.makeP2PMessageOutOfBody(&kdmFindNodeBody{id: buildTestIdFromString("1")}, KDM_FIND_NODE)
+	future := testNode.pendingRequests.add(&request, peer{})
+
+	// answer with unknown nonce
+	unknown := testNode.makeP2PMessageOutOfBody(&kdmFindNodeAnswerBody{}, KDM_FIND_NODE_ANSWER)
+	if testNode.pendingRequests.resolve(&unknown) {
+		t.Errorf("Answer with unknown nonce has to be rejected")
+	}
+
+	// answer with fitting nonce but wrong type
+	pong := testNode.makeP2PAnswerOutOfBody(nil, KDM_PONG, &request)
+	if testNode.pendingRequests.resolve(&pong) {
+		t.Errorf("KDM_PONG is no valid answer to KDM_FIND_NODE")
+	}
+
+	// fitting answer is delivered exactly once
+	answer := testNode.makeP2PAnswerOutOfBody(&kdmFindNodeAnswerBody{}, KDM_FIND_NODE_ANSWER, &request)
+	if !testNode.pendingRequests.resolve(&answer) {
+		t.Errorf("Fitting answer has to be accepted")
+	}
+	if <-future != &answer {
+		t.Errorf("Fitting answer has to be delivered to the future of the request")
+	}
+	if testNode.pendingRequests.resolve(&answer) {
+		t.Errorf("Second answer to the same request has to be rejected")
+	}
+}
+
+// Test if answers are only accepted from the queried peer and for the requested key
+func TestAnswersFromQueriedPeer(t *testing.T) {
+	requester := buildTestNode("0", 8104)
+	queried := buildTestNode("1", 8105)
+	other := buildTestNode("11", 8106)
+	key := buildTestIdFromString("111")
+
+	request := requester.makeP2PMessageOutOfBody(&kdmFindValueBody{id: key}, KDM_FIND_VALUE)
+	future := requester.pendingRequests.add(&request, queried.thisPeer)
+
+	// another peer answers with the nonce of the request
+	foreign := other.makeP2PAnswerOutOfBody(&kdmFoundValueBody{key: key, value: []byte("poisoned")}, KDM_FOUND_VALUE, &request)
+	if requester.pendingRequests.resolve(&foreign) {
+		t.Errorf("[FAILURE] answer of a peer which was not queried was accepted")
+	}
+	// the queried peer returns the value of another key
+	wrongKey := queried.makeP2PAnswerOutOfBody(&kdmFoundValueBody{key: buildTestIdFromString("110"), value: []byte("poisoned")}, KDM_FOUND_VALUE, &request)
+	if requester.pendingRequests.resolve(&wrongKey) {
+		t.Errorf("[FAILURE] value of another key was accepted")
+	}
+
+	answer := queried.makeP2PAnswerOutOfBody(&kdmFoundValueBody{key: key, value: []byte("value")}, KDM_FOUND_VALUE, &request)
+	if !requester.pendingRequests.resolve(&answer) || <-future != &answer {
+		t.Errorf("[FAILURE] answer of the queried peer was not delivered")
+	}
+
+	// the id of bootstrap peers is not known, their answers are accepted from any sender
+	ping := requester.makeP2PMessageOutOfBody(nil, KDM_PING)
+	requester.pendingRequests.add(&ping, peer{ip: "127.0.0.1", port: 8105})
+	pong := queried.makeP2PAnswerOutOfBody(nil, KDM_PONG, &ping)
+	if !requester.pendingRequests.resolve(&pong) {
+		t.Errorf("[FAILURE] answer of a bootstrap peer was rejected")
+	}
+}
+
+// Test if a KDM_STORE is written to the hashTable and acknowledged on the same connection
+func TestStoreAcknowledgment(t *testing.T) {
+	receiver := buildTestNode("1", 3333)
+	sender := buildTestNode("01", 4444)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go receiver.handleP2PConnection(server)
+
+	key := buildTestIdFromString("11")
+	request := sender.makeP2PMessageOutOfBody(&kdmStoreBody{key: key, ttl: 20, value: []byte("value")}, KDM_STORE)
+	if err := writeMessage(client, request); err != nil {
+		t.Fatal("Error while writing KDM_STORE: ", err)
+	}
+	answer, err := readMessage(client)
+	if err != nil {
+		t.Fatal("No KDM_STORE_ACK received: ", err)
+	}
+	if answer.header.messageType != KDM_STORE_ACK || answer.body.(*kdmStoreAckBody).key != key {
+		t.Errorf("KDM_STORE has to be answered with a KDM_STORE_ACK for the stored key")
+	}
+	if !bytes.Equal(answer.header.nonce, request.header.nonce) {
+		t.Errorf("KDM_STORE_ACK has to carry the nonce of the KDM_STORE")
+	}
+	if value, ok := receiver.hashTable.read(key); !ok || string(value) != "value" {
+		t.Errorf("Stored value has to be in the hashTable before it is acknowledged")
+	}
+}
+
+func TestReplicationDegree(t *testing.T) {
+	testNode := buildTestNode("1", 3333)
+	testNode.conf.MinReplication = 2
+	testNode.conf.MaxReplication = 4
+
+	if testNode.replicationDegree(0) != 4 {
+		t.Errorf("Unspecified replication has to fall back to k, bounded by maxReplication")
+	}
+	if testNode.replicationDegree(1) != 2 {
+		t.Errorf("Replication has to be bounded by minReplication")
+	}
+	if testNode.replicationDegree(3) != 3 {
+		t.Errorf("Replication within the bounds has to be used as requested")
+	}
+	if testNode.replicationDegree(200) != 4 {
+		t.Errorf("Replication has to be bounded by maxReplication")
+	}
+}
+
+// Test if the counts of a dhtPUT_ANSWER do not wrap around
+func TestClampToUint8(t *testing.T) {
+	if clampToUint8(5) != 5 || clampToUint8(255) != 255 || clampToUint8(256) != 255 {
+		t.Errorf("[FAILURE] counts above 255 have to be reported as 255")
+	}
+}
+
+// Test if a key is stored on exactly as many peers as the replication degree demands, including the local node
+func TestStoreReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storer := startTestNode(t, ctx, "000", 8110)
+	peers := []*Node{startTestNode(t, ctx, "001", 8111), startTestNode(t, ctx, "01", 8112), startTestNode(t, ctx, "1", 8113)}
+	for _, p := range peers {
+		storer.updateRoutingTable(p.thisPeer)
+	}
+
+	holders := func(key id) int {
+		number := 0
+		for _, p := range peers {
+			if _, ok := p.hashTable.read(key); ok {
+				number++
+			}
+		}
+		return number
+	}
+
+	// the storer is the closest node to the key and takes one of the replicas
+	key := buildTestIdFromString("0000")
+	confirmed, targets := storer.store(key, []byte("value"), 100, 2)
+	if confirmed != 2 || targets != 2 || holders(key) != 1 {
+		t.Errorf("[FAILURE] key closest to the storer has %d remote replicas, %d of %d confirmed", holders(key), confirmed, targets)
+	}
+
+	// the storer is not among the closest nodes
+	key = buildTestIdFromString("1111")
+	confirmed, targets = storer.store(key, []byte("value"), 100, 2)
+	if confirmed != 2 || targets != 2 || holders(key) != 2 {
+		t.Errorf("[FAILURE] key far from the storer has %d remote replicas, %d of %d confirmed", holders(key), confirmed, targets)
+	}
+}
+
+/*
+TestJoin builds a small network in which the bootstrap peer knows two further peers. A new node which only knows the
+bootstrap peer has to learn about the other peers during the join procedure. A node without any reachable
+preconfigured peer has to report that it could not join.
+*/
+func TestJoin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bootstrap := startTestNode(t, ctx, "1000", 8021)
+	peer1 := startTestNode(t, ctx, "0100", 8022)
+	peer2 := startTestNode(t, ctx, "0011", 8023)
+	bootstrap.updateRoutingTable(peer1.thisPeer)
+	bootstrap.updateRoutingTable(peer2.thisPeer)
+
+	newNode := startTestNode(t, ctx, "0001", 8024)
+	newNode.conf.BootstrapPeers = []string{"127.0.0.1:8021", "127.0.0.1:8025", "127.0.0.1:8026"}
+	if err := newNode.initializeP2PCommunication(); err != nil {
+		t.Fatal("[FAILURE] join failed: ", err)
+	}
+	known := newNode.findNumberOfClosestPeersOnNode(newNode.thisPeer.id, 5)
+	if len(known) != 3 || known[0] != peer2.thisPeer || known[1] != peer1.thisPeer || known[2] != bootstrap.thisPeer {
+		t.Errorf("[FAILURE] joined node does not know all peers of the network")
+	}
+	// the peers contacted during the join know the new node
+	if len(peer2.findNumberOfClosestPeersOnNode(newNode.thisPeer.id, 1)) != 1 {
+		t.Errorf("[FAILURE] closest neighbor does not know the joined node")
+	}
+
+	lonelyNode := startTestNode(t, ctx, "1", 8027)
+	lonelyNode.conf.BootstrapPeers = []string{"127.0.0.1:8025", "127.0.0.1:8026", "127.0.0.1:8028"}
+	if lonelyNode.initializeP2PCommunication() != ErrNoBootstrapPeer {
+		t.Errorf("[FAILURE] join without reachable preconfigured peer has to fail")
+	}
+}
+
+// Test if Start reports that no network could be joined and the node serves API requests nevertheless
+func TestStartWithoutNetwork(t *testing.T) {
+	node, err := NewNode(Options{
+		HostKeyFile:    "../config/mainHostkey.pem",
+		APIIP:          "127.0.0.1",
+		APIPort:        8126,
+		P2PIP:          "127.0.0.1",
+		P2PPort:        8125,
+		MaxTTL:         86400,
+		K:              5,
+		A:              3,
+		BootstrapPeers: []string{"127.0.0.1:8028"},
+	})
+	if err != nil {
+		t.Fatal("[FAILURE] could not create node: ", err)
+	}
+	if err = node.Start(context.Background()); err != ErrNoBootstrapPeer {
+		t.Fatal("[FAILURE] start without reachable bootstrap peer has to report ErrNoBootstrapPeer, got: ", err)
+	}
+	defer node.Close()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8126")
+	if err != nil {
+		t.Fatal("[FAILURE] could not connect to the API: ", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write(makeApiMessageOutOfBody(&getBody{key: buildTestIdFromString("1")}, dhtGET).data); err != nil {
+		t.Fatal("[FAILURE] could not write message: ", err)
+	}
+	if _, err = readAPIMessage(conn); err != nil {
+		t.Errorf("[FAILURE] node which could not join a network does not serve API requests: %v", err)
+	}
+}
+
+/*
+TestGracefulClose closes a node while a client keeps an idle API connection open. Close must not wait for the client,
+has to close its connection and has to hand over the stored keys which are not expired to the remaining peers with
+their remaining TTL.
+*/
+func TestGracefulClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remaining := startTestNode(t, ctx, "1", 8041)
+
+	leaving := buildTestNode("01", 8042)
+	var err error
+	leaving.p2pListener, err = net.Listen("tcp", "127.0.0.1:8042")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	leaving.apiListener, err = net.Listen("tcp", "127.0.0.1:8043")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	var nodeCtx context.Context
+	nodeCtx, leaving.cancel = context.WithCancel(ctx)
+	leaving.wg.Add(2)
+	go leaving.startP2PMessageDispatcher(nodeCtx)
+	go leaving.startAPIMessageDispatcher(nodeCtx)
+	leaving.updateRoutingTable(remaining.thisPeer)
+
+	key := buildTestIdFromString("11")
+	expiredKey := buildTestIdFromString("111")
+	leaving.hashTable.write(key, []byte("value"), time.Now().Add(100*time.Second), time.Now().Add(time.Hour), 0)
+	leaving.hashTable.write(expiredKey, []byte("expired"), time.Now().Add(-time.Second), time.Now().Add(time.Hour), 0)
+
+	client, err := net.Dial("tcp", "127.0.0.1:8043")
+	if err != nil {
+		t.Fatal("[FAILURE] could not connect to API: ", err)
+	}
+	defer client.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- leaving.Close() }()
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Errorf("[FAILURE] Close failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("[FAILURE] Close waits for an idle API connection")
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("[FAILURE] idle API connection was not closed: %v", err)
+	}
+	entry, ok := remaining.hashTable.storage.read(key)
+	if !ok || string(entry.value) != "value" {
+		t.Fatal("[FAILURE] key was not handed over")
+	}
+	if ttl := time.Until(entry.expiration); ttl > 100*time.Second || ttl < 95*time.Second {
+		t.Errorf("[FAILURE] key was not handed over with its remaining TTL, it expires in %v", ttl)
+	}
+	if _, ok = remaining.hashTable.read(expiredKey); ok {
+		t.Errorf("[FAILURE] expired key was handed over")
+	}
+}
+
+/*
+TestReplicateKeysToNewcomer stores two keys on a node with a replication degree of 1. When a new peer enters its routing
+table, only the key to which the new peer is closer than the node itself has to be transferred to it.
+*/
+func TestReplicateKeysToNewcomer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newcomer := startTestNode(t, ctx, "01", 8051)
+
+	thisNode := buildTestNode("00", 8050)
+	// a previous holder which is closer to heldKey than the local node, it does not have to be reachable
+	holder := buildTestNode("1", 8052)
+	thisNode.routingTreeLock.Lock()
+	thisNode.insertIntoRoutingTree(holder.thisPeer)
+	thisNode.routingTreeLock.Unlock()
+
+	closerKey := buildTestIdFromString("011")
+	fartherKey := buildTestIdFromString("0001")
+	heldKey := buildTestIdFromString("11")
+	thisNode.hashTable.write(closerKey, []byte("closer"), time.Now().Add(100*time.Second), time.Now().Add(time.Hour), 1)
+	thisNode.hashTable.write(fartherKey, []byte("farther"), time.Now().Add(100*time.Second), time.Now().Add(time.Hour), 1)
+	thisNode.hashTable.write(heldKey, []byte("held"), time.Now().Add(100*time.Second), time.Now().Add(time.Hour), 3)
+
+	thisNode.updateRoutingTable(newcomer.thisPeer)
+	thisNode.wg.Wait()
+	if _, ok := newcomer.hashTable.read(closerKey); !ok {
+		t.Fatal("[FAILURE] key was not replicated to the closer newcomer")
+	}
+	entry, _ := newcomer.hashTable.storage.read(closerKey)
+	if entry.replication != 1 || time.Until(entry.expiration) < 95*time.Second {
+		t.Errorf("[FAILURE] key was not replicated with its replication degree and remaining TTL")
+	}
+	if _, ok := newcomer.hashTable.read(fartherKey); ok {
+		t.Errorf("[FAILURE] key for which the node itself is closer was replicated")
+	}
+	if _, ok := newcomer.hashTable.read(heldKey); ok {
+		t.Errorf("[FAILURE] key was replicated although a closer holder is known")
+	}
+}
+
+// Test if a closing node does not start the replication to newcomers, which Close would not wait for
+func TestReplicationAfterClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newcomer := startTestNode(t, ctx, "01", 8121)
+
+	thisNode := buildTestNode("00", 8122)
+	key := buildTestIdFromString("011")
+	thisNode.hashTable.write(key, []byte("closer"), time.Now().Add(100*time.Second), time.Now().Add(time.Hour), 1)
+	thisNode.ctx, thisNode.cancel = context.WithCancel(context.Background())
+	thisNode.cancel()
+
+	thisNode.updateRoutingTable(newcomer.thisPeer)
+	thisNode.wg.Wait()
+	thisNode.replicationQueue.Lock()
+	running := thisNode.replicationQueue.running
+	thisNode.replicationQueue.Unlock()
+	if running {
+		t.Errorf("[FAILURE] replication worker was started by a closing node")
+	}
+	if _, ok := newcomer.hashTable.read(key); ok {
+		t.Errorf("[FAILURE] key was replicated by a closing node")
+	}
+}
+
+/*
+TestRepublishKeys checks that only due keys are republished, with their remaining TTL in seconds, that their
+republishing time is reset and that a key stored again after it was taken for republishing is skipped.
+*/
+func TestRepublishKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replica := startTestNode(t, ctx, "1", 8061)
+
+	thisNode := buildTestNode("0", 8060)
+	// insert the replica without triggering the replication to newcomers
+	thisNode.routingTreeLock.Lock()
+	thisNode.insertIntoRoutingTree(replica.thisPeer)
+	thisNode.routingTreeLock.Unlock()
+
+	now := time.Now()
+	dueKey := buildTestIdFromString("11")
+	notDueKey := buildTestIdFromString("111")
+	thisNode.hashTable.write(dueKey, []byte("due"), now.Add(100*time.Second), now.Add(-time.Second), 0)
+	thisNode.hashTable.write(notDueKey, []byte("not due"), now.Add(100*time.Second), now.Add(time.Minute), 0)
+
+	thisNode.hashTable.republishKeys(thisNode)
+	thisNode.wg.Wait()
+	entry, ok := replica.hashTable.storage.read(dueKey)
+	if !ok {
+		t.Fatal("[FAILURE] due key was not republished")
+	}
+	if ttl := time.Until(entry.expiration); ttl > 100*time.Second || ttl < 95*time.Second {
+		t.Errorf("[FAILURE] key was not republished with its remaining TTL, it expires in %v", ttl)
+	}
+	if _, ok = replica.hashTable.read(notDueKey); ok {
+		t.Errorf("[FAILURE] key which is not due was republished")
+	}
+	if entry, _ = thisNode.hashTable.storage.read(dueKey); time.Until(entry.republishingTime) < 59*time.Minute {
+		t.Errorf("[FAILURE] republishing time was not reset")
+	}
+
+	// the key is stored again with a KDM_STORE after it was taken for republishing
+	skippedKey := buildTestIdFromString("1111")
+	thisNode.hashTable.write(skippedKey, []byte("skipped"), now.Add(100*time.Second), now.Add(-time.Second), 0)
+	taken := thisNode.hashTable.storage.takeDueForRepublishing(time.Now(), time.Now().Add(time.Hour))[skippedKey]
+	thisNode.hashTable.write(skippedKey, []byte("skipped"), now.Add(100*time.Second), time.Now().Add(time.Hour), 0)
+	thisNode.hashTable.republish(thisNode, skippedKey, taken)
+	if _, ok = replica.hashTable.read(skippedKey); ok {
+		t.Errorf("[FAILURE] key stored again in the meantime was republished")
+	}
+}
+
+// Test if pairs cached on a lookup path are neither replicated to newcomers, handed over nor republished
+func TestCachedPairs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newcomer := startTestNode(t, ctx, "1", 8118)
+
+	thisNode := buildTestNode("0", 8117)
+	thisNode.routingTreeLock.Lock()
+	thisNode.insertIntoRoutingTree(newcomer.thisPeer)
+	thisNode.routingTreeLock.Unlock()
+
+	now := time.Now()
+	cachedKey := buildTestIdFromString("11")
+	replicaKey := buildTestIdFromString("111")
+	thisNode.hashTable.writeCached(cachedKey, []byte("cached"), now.Add(100*time.Second))
+	thisNode.hashTable.write(replicaKey, []byte("replica"), now.Add(100*time.Second), now.Add(-time.Second), 1)
+	// a cached copy does not replace a replica
+	thisNode.hashTable.writeCached(replicaKey, []byte("cached"), now.Add(time.Second))
+	if entry, _ := thisNode.hashTable.storage.read(replicaKey); entry.cached || string(entry.value) != "replica" {
+		t.Errorf("[FAILURE] replica was replaced by a cached copy")
+	}
+
+	thisNode.replicateKeysTo(map[id]peer{newcomer.thisPeer.id: newcomer.thisPeer})
+	if _, ok := newcomer.hashTable.read(cachedKey); ok {
+		t.Errorf("[FAILURE] cached pair was replicated to a newcomer")
+	}
+	if _, ok := newcomer.hashTable.read(replicaKey); !ok {
+		t.Errorf("[FAILURE] replica was not replicated to a newcomer")
+	}
+	if due := thisNode.hashTable.storage.takeDueForRepublishing(time.Now(), time.Now().Add(time.Hour)); len(due) != 1 {
+		t.Errorf("[FAILURE] %d pairs are due for republishing instead of the replica only", len(due))
+	}
+	if handedOver := thisNode.hashTable.handOverKeys(thisNode); handedOver != 1 {
+		t.Errorf("[FAILURE] %d pairs were handed over instead of the replica only", handedOver)
+	}
+
+	// the flag of a KDM_STORE marks the pair as cached at the receiver
+	store := thisNode.makeP2PMessageOutOfBody(&kdmStoreBody{key: cachedKey, ttl: 20, flags: STORE_FLAG_CACHED, value: []byte("cached")}, KDM_STORE)
+	if _, err := thisNode.sendRequest(store, newcomer.thisPeer); err != nil {
+		t.Fatal("[FAILURE] KDM_STORE was not acknowledged: ", err)
+	}
+	if entry, ok := newcomer.hashTable.storage.read(cachedKey); !ok || !entry.cached {
+		t.Errorf("[FAILURE] pair stored with the cached flag was not marked as cached")
+	}
+}
+
+// builds a node that is not started with an id starting with the given prefix
+// the id belongs to a generated host key, so the messages of the node pass the verification of their signature
+func buildTestNode(prefix string, port uint16) *Node {
+	hostKey, publicKey, testID := buildTestHostKey(prefix)
+	testNode := &Node{
+		conf:        Options{P2PIP: "127.0.0.1", P2PPort: port, MaxTTL: 86400, K: 5, A: 3, MinReplication: 1, MaxReplication: 5},
+		thisPeer:    peer{id: testID, ip: "127.0.0.1", port: port},
+		routingTree: *buildEmptyTestRoutingTree(),
+		hashTable:   hashTable{storage: newMemoryStorage()},
+		hostKey:     hostKey,
+		publicKey:   publicKey,
+	}
+	return testNode
+}
+
+// generates Ed25519 host keys until the id of one starts with the given prefix, so prefixes should be short
+func buildTestHostKey(prefix string) (crypto.Signer, []byte, id) {
+	for {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err.Error())
+		}
+		publicKey, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			panic(err.Error())
+		}
+		if testID := idOfPublicKey(publicKey); testID.startsWith(prefix) {
+			return private, publicKey, testID
+		}
+	}
+}
+
+// builds a test node which answers P2P requests until ctx is canceled
+func startTestNode(t *testing.T, ctx context.Context, prefix string, port uint16) *Node {
+	return serveTestNode(t, ctx, buildTestNode(prefix, port))
+}
+
+// lets the given test node answer P2P requests until ctx is canceled
+func serveTestNode(t *testing.T, ctx context.Context, testNode *Node) *Node {
+	var err error
+	testNode.p2pListener, err = testNode.listenP2P("127.0.0.1:" + strconv.Itoa(int(testNode.thisPeer.port)))
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	testNode.wg.Add(1)
+	go testNode.startP2PMessageDispatcher(ctx)
+	return testNode
+}
+
+// Test if the republishing workers are shared between the timer ticks and a pair still being republished is skipped
+func TestRepublishQueue(t *testing.T) {
+	thisNode := buildTestNode("0", 8120)
+	now := time.Now()
+	runningKey := buildTestIdFromString("1")
+	thisNode.hashTable.write(runningKey, []byte("running"), now.Add(100*time.Second), now.Add(-time.Second), 0)
+	for i := 0; i < 2*PARALLEL_REPUBLISHES; i++ {
+		key := buildTestIdFromString("01" + strconv.FormatInt(int64(i), 2))
+		thisNode.hashTable.write(key, []byte("due"), now.Add(100*time.Second), now.Add(-time.Second), 0)
+	}
+	thisNode.republishQueue.running = map[id]bool{runningKey: true}
+	thisNode.republishQueue.pending = make(map[id]storageEntry)
+
+	thisNode.hashTable.republishKeys(thisNode)
+	thisNode.republishQueue.Lock()
+	if _, ok := thisNode.republishQueue.pending[runningKey]; ok {
+		t.Errorf("[FAILURE] pair whose republishing is running was queued again")
+	}
+	if thisNode.republishQueue.workers > PARALLEL_REPUBLISHES {
+		t.Errorf("[FAILURE] %d workers were started", thisNode.republishQueue.workers)
+	}
+	thisNode.republishQueue.Unlock()
+
+	// the next tick does not start further workers while the queued pairs are republished
+	thisNode.hashTable.write(buildTestIdFromString("001"), []byte("due"), now.Add(100*time.Second), now.Add(-time.Second), 0)
+	thisNode.hashTable.republishKeys(thisNode)
+	thisNode.republishQueue.Lock()
+	if thisNode.republishQueue.workers > PARALLEL_REPUBLISHES {
+		t.Errorf("[FAILURE] %d workers were started", thisNode.republishQueue.workers)
+	}
+	thisNode.republishQueue.Unlock()
+
+	thisNode.wg.Wait()
+	if len(thisNode.republishQueue.pending) != 0 || thisNode.republishQueue.workers != 0 {
+		t.Errorf("[FAILURE] workers did not republish all queued pairs")
+	}
+}
@@ -1,14 +1,18 @@
-package main
+package dht
 
 import "C"
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net"
 	//	"net/http/httptest"
 	"strconv"
 	//	"strings"
+	"time"
 )
 
 const KDM_PING uint16 = 654
@@ -20,13 +24,15 @@ const KDM_FIND_VALUE uint16 = 659
 
 //const KDM_FIND_VALUE_ANSWER uint16 = 660  //KDM_FIND_VALUE_ANSWER is  same as KDM_FIND_NODE_ANSWER
 const KDM_FOUND_VALUE uint16 = 661
+const KDM_STORE_ACK uint16 = 662
 
 const SIZE_OF_IP int = 16
 const SIZE_OF_PORT int = 2
 const SIZE_OF_ID int = 32
 const SIZE_OF_PEER int = SIZE_OF_ID + SIZE_OF_IP + SIZE_OF_PORT
 const SIZE_OF_NONCE int = 20
-const SIZE_OF_HEADER = 4 + SIZE_OF_PEER + SIZE_OF_NONCE
+const SIZE_OF_TIMESTAMP int = 8
+const SIZE_OF_HEADER = 4 + SIZE_OF_PEER + SIZE_OF_NONCE + SIZE_OF_TIMESTAMP
 
 const REPUBLISH_TIME int = 3600 // republish every 3600s
 
@@ -36,6 +42,11 @@ type p2pMessage struct {
 	header p2pHeader
 	body   p2pBody
 	data   []byte
+	// public key of the sender and its signature over data, sent after data (see signing.go)
+	publicKey []byte
+	signature []byte
+	// solution of the dynamic puzzle of the sender, sent after the signature (see puzzles.go)
+	puzzleSolution id
 }
 
 func (m *p2pMessage) toString() string {
@@ -57,6 +68,8 @@ type p2pHeader struct {
 	messageType uint16
 	senderPeer  peer
 	nonce       []byte
+	// time at which the message was built in milliseconds since the epoch, used against replays (see replay.go)
+	timestamp int64
 }
 
 func (h *p2pHeader) decodeHeaderToBytes() []byte {
@@ -65,6 +78,9 @@ func (h *p2pHeader) decodeHeaderToBytes() []byte {
 	binary.BigEndian.PutUint16(result[2:4], h.messageType)
 	result = append(result, decodePeerToByte(h.senderPeer)...)
 	result = append(result, h.nonce...)
+	timestamp := make([]byte, SIZE_OF_TIMESTAMP)
+	binary.BigEndian.PutUint64(timestamp, uint64(h.timestamp))
+	result = append(result, timestamp...)
 	return result
 }
 func (h *p2pHeader) toString() string {
@@ -135,10 +151,18 @@ func (b *kdmFoundValueBody) toString() string {
 	return "[key: " + bytesToString(b.key.toByte()) + ", value: " + bytesToString(b.value) + "]"
 }
 
+// flags of a KDM_STORE, sent in the byte following the replication degree
+const (
+	// the pair is a copy cached on a lookup path, the receiver must not replicate, hand over or republish it
+	STORE_FLAG_CACHED uint8 = 1
+)
+
 type kdmStoreBody struct {
-	key   id
-	ttl   uint16
-	value []byte
+	key         id
+	ttl         uint16
+	replication uint8
+	flags       uint8
+	value       []byte
 }
 
 func (b *kdmStoreBody) decodeBodyFromBytes(m *p2pMessage) {
@@ -149,7 +173,9 @@ func (b *kdmStoreBody) decodeBodyFromBytes(m *p2pMessage) {
 	copy(i[:], idHelp)
 	b.key = i
 	b.ttl = binary.BigEndian.Uint16(m.data[SIZE_OF_HEADER+SIZE_OF_ID : SIZE_OF_HEADER+SIZE_OF_ID+2])
-	b.value = m.data[SIZE_OF_HEADER+SIZE_OF_ID+2:]
+	b.replication = m.data[SIZE_OF_HEADER+SIZE_OF_ID+2]
+	b.flags = m.data[SIZE_OF_HEADER+SIZE_OF_ID+3]
+	b.value = m.data[SIZE_OF_HEADER+SIZE_OF_ID+4:]
 }
 func (b *kdmStoreBody) decodeBodyToBytes() []byte {
 	var result []byte
@@ -158,11 +184,29 @@ func (b *kdmStoreBody) decodeBodyToBytes() []byte {
 	result = append(result, 0)
 	result = append(result, 0)
 	binary.BigEndian.PutUint16(result[SIZE_OF_ID:SIZE_OF_ID+2], b.ttl)
+	result = append(result, b.replication)
+	result = append(result, b.flags)
 	result = append(result, b.value...)
 	return result
 }
 func (b *kdmStoreBody) toString() string {
-	return "[Key: " + bytesToString(b.key.toByte()) + "](" + strconv.Itoa(int(b.ttl)) + ")\n     [value:" + bytesToString(b.value) + "]"
+	return "[Key: " + bytesToString(b.key.toByte()) + "](" + strconv.Itoa(int(b.ttl)) + ", replication: " + strconv.Itoa(int(b.replication)) + ")\n     [value:" + bytesToString(b.value) + "]"
+}
+
+type kdmStoreAckBody struct {
+	key id
+}
+
+func (b *kdmStoreAckBody) decodeBodyFromBytes(m *p2pMessage) {
+	var key id
+	copy(key[:], m.data[SIZE_OF_HEADER:SIZE_OF_HEADER+SIZE_OF_ID])
+	b.key = key
+}
+func (b *kdmStoreAckBody) decodeBodyToBytes() []byte {
+	return b.key.toByte()
+}
+func (b *kdmStoreAckBody) toString() string {
+	return "[Key: " + bytesToString(b.key.toByte()) + "]"
 }
 
 type kdmFindNodeAnswerBody struct {
@@ -193,36 +237,94 @@ func (b *kdmFindNodeAnswerBody) toString() string {
 	return result
 }
 
-func readMessage(conn net.Conn) *p2pMessage {
-	receivedMessageRaw := make([]byte, maxMessageLength)
-	msgSize, err := conn.Read(receivedMessageRaw)
-	log.Debug("received message: ", receivedMessageRaw[:30], " ...")
-	if err != nil {
-		custError := "[pot. FAILURE] MAIN: Error while reading from connection: " + err.Error() + " (This might be because no more data was sent)"
-		log.Error(custError)
-		conn.Close()
-		return nil
+// errors returned by readMessage if the received bytes do not form a valid p2pMessage
+var (
+	errMessageTooShort    = errors.New("message is shorter than required for its type")
+	errMessageTooLong     = errors.New("message is longer than allowed for its type")
+	errUnknownMessageType = errors.New("message has an unknown type")
+)
+
+// checks if the size specified in the header is valid for the given message type
+func validateP2PMessageSize(messageType uint16, size int) error {
+	if size < SIZE_OF_HEADER {
+		return errMessageTooShort
 	}
-	if msgSize > maxMessageLength {
-		custError := "[FAILURE] MAIN: Too much data was sent to us: " + strconv.Itoa(msgSize)
-		log.Error(custError)
-		conn.Close()
-		return nil
+	bodySize := size - SIZE_OF_HEADER
+	switch messageType {
+	case KDM_PING, KDM_PONG:
+		if bodySize != 0 {
+			return errMessageTooLong
+		}
+	case KDM_FIND_NODE, KDM_FIND_VALUE, KDM_STORE_ACK:
+		if bodySize < SIZE_OF_ID {
+			return errMessageTooShort
+		}
+		if bodySize > SIZE_OF_ID {
+			return errMessageTooLong
+		}
+	case KDM_STORE:
+		if bodySize < SIZE_OF_ID+4 {
+			return errMessageTooShort
+		}
+	case KDM_FOUND_VALUE:
+		if bodySize < SIZE_OF_ID {
+			return errMessageTooShort
+		}
+	case KDM_FIND_NODE_ANSWER:
+		if bodySize%SIZE_OF_PEER != 0 {
+			return errMessageTooShort
+		}
+	default:
+		return errUnknownMessageType
+	}
+	return nil
+}
+
+/*
+readMessage reads exactly one p2pMessage from the given reader. The first 4 bytes of the header (size and type) are read
+first, then exactly size-4 further bytes. Therefore messages that are split into several segments are reassembled and
+no bytes of a following message are consumed. An error is returned if the stream ends early or if the size is not
+valid for the message type.
+*/
+func readMessage(reader io.Reader) (*p2pMessage, error) {
+	prefix := make([]byte, 4)
+	_, err := io.ReadFull(reader, prefix)
+	if err != nil {
+		return nil, err
 	}
 
-	size := binary.BigEndian.Uint16(receivedMessageRaw[:2])
+	size := int(binary.BigEndian.Uint16(prefix[:2]))
+	messageType := binary.BigEndian.Uint16(prefix[2:4])
 	log.Debug("Received message has size: ", size)
-	log.Debug("Received message, data: ", receivedMessageRaw[:size])
-	if uint16(msgSize) != size {
-		custError := "[FAILURE] MAIN: Message size (" + strconv.Itoa(msgSize) + ") does not match specified 'size': " + strconv.Itoa(int(size))
-		log.Error(custError)
-		log.Error("!!!", receivedMessageRaw[:msgSize])
-		conn.Close()
-		return nil
+	err = validateP2PMessageSize(messageType, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w (type %d, size %d)", err, messageType, size)
+	}
+
+	receivedMessageRaw := make([]byte, size)
+	copy(receivedMessageRaw, prefix)
+	_, err = io.ReadFull(reader, receivedMessageRaw[len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Received message, data: ", receivedMessageRaw)
+
+	receivedMsg := makeP2PMessageOutOfBytes(receivedMessageRaw)
+	err = readSignature(reader, &receivedMsg)
+	if err != nil {
+		return nil, err
 	}
-	receivedMsg := makeP2PMessageOutOfBytes(receivedMessageRaw[:msgSize])
 	log.Debug("Going to return: ", receivedMsg.toString())
-	return &receivedMsg
+	return &receivedMsg, nil
+}
+
+// writes the data of message m followed by its public key and signature to the given writer
+func writeMessage(writer io.Writer, m p2pMessage) error {
+	if len(m.data) != int(m.header.size) {
+		return errors.New("Message size (" + strconv.Itoa(len(m.data)) + ") does not match specified 'size': " + strconv.Itoa(int(m.header.size)))
+	}
+	_, err := writer.Write(append(m.data[:len(m.data):len(m.data)], encodeSignature(&m)...))
+	return err
 }
 
 func makeP2PMessageOutOfBytes(messageData []byte) p2pMessage {
@@ -236,6 +338,7 @@ func makeP2PMessageOutOfBytes(messageData []byte) p2pMessage {
 	msg.header.messageType = binary.BigEndian.Uint16(messageData[2:4])
 	msg.header.senderPeer = decodeBytesToPeer(messageData[4 : 4+SIZE_OF_PEER])
 	msg.header.nonce = messageData[4+SIZE_OF_PEER : 4+SIZE_OF_PEER+SIZE_OF_NONCE]
+	msg.header.timestamp = int64(binary.BigEndian.Uint64(messageData[4+SIZE_OF_PEER+SIZE_OF_NONCE : SIZE_OF_HEADER]))
 
 	//store data in raw
 	msg.data = messageData
@@ -260,20 +363,33 @@ func makeP2PMessageOutOfBytes(messageData []byte) p2pMessage {
 	case KDM_FOUND_VALUE:
 		msg.body = &kdmFoundValueBody{}
 		msg.body.decodeBodyFromBytes(&msg)
+	case KDM_STORE_ACK:
+		msg.body = &kdmStoreAckBody{}
+		msg.body.decodeBodyFromBytes(&msg)
 	}
 	return msg
 }
 
-func makeP2PMessageOutOfBody(body p2pBody, msgType uint16) p2pMessage {
-	result := p2pMessage{}
-	result.header.messageType = msgType
-
-	result.header.senderPeer = thisNode.thisPeer
+// generates a signed message with a new random nonce
+func (thisNode *Node) makeP2PMessageOutOfBody(body p2pBody, msgType uint16) p2pMessage {
 	nonce := make([]byte, SIZE_OF_NONCE)
 	if _, err := rand.Read(nonce); err != nil {
 		panic(err.Error())
 	}
+	result := thisNode.buildP2PMessage(body, msgType, nonce)
+	thisNode.signMessage(&result)
+	return result
+}
+
+// generates an unsigned message with the given nonce
+func (thisNode *Node) buildP2PMessage(body p2pBody, msgType uint16, nonce []byte) p2pMessage {
+	result := p2pMessage{}
+	result.header.messageType = msgType
+
+	result.header.senderPeer = thisNode.thisPeer
 	result.header.nonce = nonce
+	result.header.timestamp = timestampOf(time.Now())
+	result.puzzleSolution = thisNode.puzzleSolution
 	log.Debug(result.header.messageType, result.header.nonce)
 	log.Debug(result.header.senderPeer)
 	if msgType == KDM_PING || msgType == KDM_PONG {
@@ -292,31 +408,12 @@ func makeP2PMessageOutOfBody(body p2pBody, msgType uint16) p2pMessage {
 	return result
 }
 
-//sends the data of message m to the receiver peer
-func sendP2PMessage(m p2pMessage, receiverPeer peer) {
-	_, err := net.ResolveTCPAddr("tcp", m.header.senderPeer.ip+":"+strconv.Itoa(int(m.header.senderPeer.port)))
-	if err != nil {
-		custError := "[FAILURE] Error while parsing to TCP addr: " + err.Error()
-		log.Panic(custError)
-	}
-	_, err = net.ResolveTCPAddr("tcp", receiverPeer.ip+":"+strconv.Itoa(int(receiverPeer.port)))
-	if err != nil {
-		custError := "[FAILURE] Error while while parsing to TCP addr:" + err.Error()
-		log.Error(custError)
-		return
-	}
-	conn, err := net.Dial("tcp", receiverPeer.ip+":"+strconv.Itoa(int(receiverPeer.port)))
-	if err != nil {
-		custError := "[FAILURE] Error while connecting via tcp:" + err.Error() + ", for " + receiverPeer.ip + ":" + strconv.Itoa(int(receiverPeer.port))
-		log.Error(custError)
-		return
-	}
-	_, err = conn.Write(m.data)
-	if err != nil {
-		custError := "[FAILURE] Writing to connection failed:" + err.Error()
-		log.Error(custError)
-		return
-	}
+// generates the answer to the given request
+// the answer carries the nonce of the request, so that the requesting peer can correlate both
+func (thisNode *Node) makeP2PAnswerOutOfBody(body p2pBody, msgType uint16, request *p2pMessage) p2pMessage {
+	result := thisNode.buildP2PMessage(body, msgType, request.header.nonce)
+	thisNode.signMessage(&result)
+	return result
 }
 
 //parses a peer into byte representation
@@ -326,6 +423,10 @@ func decodePeerToByte(peer peer) []byte {
 
 	//first field = IP
 	ip := net.ParseIP(peer.ip).To16()
+	if ip == nil {
+		// keep the fixed size of a peer even if the ip cannot be parsed
+		ip = make(net.IP, SIZE_OF_IP)
+	}
 	result = append(result, ip...)
 
 	//second field = port
@@ -1,24 +1,29 @@
-package main
+package dht
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"testing"
+	"testing/iotest"
 )
 
 func TestPingCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
-	ping1 := makeP2PMessageOutOfBody(nil, KDM_PING)
+	testNode.thisPeer.id = i
+	ping1 := testNode.makeP2PMessageOutOfBody(nil, KDM_PING)
 	fmt.Println("Ping1: ", ping1.toString())
 	if ping1.body != nil {
 		t.Errorf("Body of KDM_PING message is not nil")
@@ -51,16 +56,17 @@ func TestPingCodingAndDecoding(t *testing.T) {
 }
 
 func TestPongCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
-	pong1 := makeP2PMessageOutOfBody(nil, KDM_PONG)
+	testNode.thisPeer.id = i
+	pong1 := testNode.makeP2PMessageOutOfBody(nil, KDM_PONG)
 	fmt.Println("Pong1: ", pong1.toString())
 	if pong1.body != nil {
 		t.Errorf("Body of KDM_PING message is not nil")
@@ -93,15 +99,16 @@ func TestPongCodingAndDecoding(t *testing.T) {
 }
 
 func TestStoreCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	idy := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idy); err != nil {
@@ -115,12 +122,14 @@ func TestStoreCodingAndDecoding(t *testing.T) {
 		panic(err.Error())
 	}
 	storeBdy := kdmStoreBody{
-		key:   i2,
-		ttl:   15,
-		value: value,
+		key:         i2,
+		ttl:         15,
+		replication: 7,
+		flags:       STORE_FLAG_CACHED,
+		value:       value,
 	}
 
-	kdmStore1 := makeP2PMessageOutOfBody(&storeBdy, KDM_STORE)
+	kdmStore1 := testNode.makeP2PMessageOutOfBody(&storeBdy, KDM_STORE)
 	fmt.Println("KDM_Store1: ", kdmStore1.toString())
 	if kdmStore1.body == nil {
 		t.Errorf("Body of KDM_Store message is  nil")
@@ -156,15 +165,16 @@ func TestStoreCodingAndDecoding(t *testing.T) {
 }
 
 func TestFindNodeCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	idy := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idy); err != nil {
@@ -177,7 +187,7 @@ func TestFindNodeCodingAndDecoding(t *testing.T) {
 		id: i2,
 	}
 
-	findNode1 := makeP2PMessageOutOfBody(&findNodeBdy, KDM_FIND_NODE)
+	findNode1 := testNode.makeP2PMessageOutOfBody(&findNodeBdy, KDM_FIND_NODE)
 	fmt.Println("FindNode1: ", findNode1.toString())
 	if findNode1.body == nil {
 		t.Errorf("Body of FindNode message is  nil")
@@ -213,15 +223,16 @@ func TestFindNodeCodingAndDecoding(t *testing.T) {
 }
 
 func TestFindNodeAnswerCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	var ps []peer
 	for i := 0; i < 5; i++ {
@@ -246,7 +257,7 @@ func TestFindNodeAnswerCodingAndDecoding(t *testing.T) {
 		answerPeers: ps,
 	}
 
-	findNodeAnswer := makeP2PMessageOutOfBody(&findNodeBdy, KDM_FIND_NODE_ANSWER)
+	findNodeAnswer := testNode.makeP2PMessageOutOfBody(&findNodeBdy, KDM_FIND_NODE_ANSWER)
 	fmt.Println("FindNodeAnswer1: ", findNodeAnswer.toString())
 	if findNodeAnswer.body == nil {
 		t.Errorf("Body of FindNodeAnswer message is  nil")
@@ -282,15 +293,16 @@ func TestFindNodeAnswerCodingAndDecoding(t *testing.T) {
 }
 
 func TestFindValueCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	idy := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idy); err != nil {
@@ -303,7 +315,7 @@ func TestFindValueCodingAndDecoding(t *testing.T) {
 		id: i2,
 	}
 
-	findValue1 := makeP2PMessageOutOfBody(&findValueBody, KDM_FIND_VALUE)
+	findValue1 := testNode.makeP2PMessageOutOfBody(&findValueBody, KDM_FIND_VALUE)
 	fmt.Println("FindValue1: ", findValue1.toString())
 	if findValue1.body == nil {
 		t.Errorf("Body of FindValue message is  nil")
@@ -339,15 +351,16 @@ func TestFindValueCodingAndDecoding(t *testing.T) {
 }
 
 func TestFoundValueCodingAndDecoding(t *testing.T) {
-	thisNode.thisPeer.ip = "1.4.2.3"
-	thisNode.thisPeer.port = 30
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
 	idx := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idx); err != nil {
 		panic(err.Error())
 	}
 	var i id
 	copy(i[:], idx)
-	thisNode.thisPeer.id = i
+	testNode.thisPeer.id = i
 
 	idy := make([]byte, SIZE_OF_ID)
 	if _, err := rand.Read(idy); err != nil {
@@ -366,7 +379,7 @@ func TestFoundValueCodingAndDecoding(t *testing.T) {
 		value: value,
 	}
 
-	foundValue1 := makeP2PMessageOutOfBody(&foundValueBdy, KDM_FOUND_VALUE)
+	foundValue1 := testNode.makeP2PMessageOutOfBody(&foundValueBdy, KDM_FOUND_VALUE)
 	fmt.Println("FoundValue1: ", foundValue1.toString())
 	if foundValue1.body == nil {
 		t.Errorf("Body of FoundValue message is  nil")
@@ -426,7 +439,7 @@ func TestParsePeerToByte(t *testing.T) {
 	// check if first SIZE_OF_ID bytes are equal to id
 	for i := 0; i < SIZE_OF_ID; i++ {
 		if newPeer.id[i] != randIdBytes[i] {
-			t.Errorf("First " + fmt.Sprint(SIZE_OF_ID) + " Bytes have to be equal to Id")
+			t.Error("First " + fmt.Sprint(SIZE_OF_ID) + " Bytes have to be equal to Id")
 		}
 	}
 	fmt.Println(peer)
@@ -438,3 +451,58 @@ func TestParsePeerToByte(t *testing.T) {
 	}
 
 }
+
+func TestReadMessageFraming(t *testing.T) {
+	testNode := buildTestNode("", 0)
+	testNode.thisPeer.ip = "1.4.2.3"
+	testNode.thisPeer.port = 30
+
+	storeBody := kdmStoreBody{key: buildTestIdFromString("1010"), ttl: 20, value: []byte("value")}
+	store := testNode.makeP2PMessageOutOfBody(&storeBody, KDM_STORE)
+	ping := testNode.makeP2PMessageOutOfBody(nil, KDM_PING)
+
+	// two messages delivered byte by byte have to be read as two complete messages
+	var stream bytes.Buffer
+	if err := writeMessage(&stream, store); err != nil {
+		t.Fatal("Writing of message failed: ", err)
+	}
+	if err := writeMessage(&stream, ping); err != nil {
+		t.Fatal("Writing of message failed: ", err)
+	}
+	reader := iotest.OneByteReader(&stream)
+
+	m, err := readMessage(reader)
+	if err != nil {
+		t.Fatal("Reading of first message failed: ", err)
+	}
+	if !reflect.DeepEqual(m.data, store.data) || !reflect.DeepEqual(m.body, store.body) {
+		t.Errorf("First message was not read correctly")
+	}
+	m, err = readMessage(reader)
+	if err != nil {
+		t.Fatal("Reading of second message failed: ", err)
+	}
+	if !reflect.DeepEqual(m.data, ping.data) {
+		t.Errorf("Second message was not read correctly")
+	}
+
+	// a truncated message has to return an error instead of a message
+	m, err = readMessage(bytes.NewReader(store.data[:len(store.data)-1]))
+	if err == nil || m != nil {
+		t.Errorf("Truncated message has to be rejected")
+	}
+
+	// a size smaller than required for the message type has to be rejected
+	short := append([]byte{}, store.data[:SIZE_OF_HEADER+SIZE_OF_ID]...)
+	binary.BigEndian.PutUint16(short[:2], uint16(len(short)))
+	if _, err = readMessage(bytes.NewReader(short)); !errors.Is(err, errMessageTooShort) {
+		t.Errorf("KDM_STORE without ttl has to be rejected as too short, got: %v", err)
+	}
+
+	// unknown message types have to be rejected
+	unknown := append([]byte{}, ping.data...)
+	binary.BigEndian.PutUint16(unknown[2:4], 1)
+	if _, err = readMessage(bytes.NewReader(unknown)); !errors.Is(err, errUnknownMessageType) {
+		t.Errorf("Message with unknown type has to be rejected, got: %v", err)
+	}
+}
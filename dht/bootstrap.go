@@ -0,0 +1,121 @@
+package dht
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+bootstrapPeers collects the addresses of all peers which are contacted to join the network: the configured list, the
+entries of the seed file, all addresses the bootstrap DNS name resolves to and the peers of the peer cache written at
+the last shutdown. Sources which can not be read and malformed addresses are logged and skipped, duplicates and the own
+address are removed.
+*/
+func (thisNode *Node) bootstrapPeers() []peer {
+	addresses := append([]string{}, thisNode.conf.BootstrapPeers...)
+
+	if thisNode.conf.SeedFile != "" {
+		seeds, err := readPeerFile(thisNode.conf.SeedFile)
+		if err != nil {
+			log.Error("[FAILURE] Could not read seed file: ", err)
+		}
+		addresses = append(addresses, seeds...)
+	}
+	if thisNode.conf.BootstrapDNS != "" {
+		resolved, err := resolveBootstrapDNS(thisNode.conf.BootstrapDNS)
+		if err != nil {
+			log.Error("[FAILURE] Could not resolve bootstrap DNS name: ", err)
+		}
+		addresses = append(addresses, resolved...)
+	}
+	if thisNode.conf.PeerCacheFile != "" {
+		cached, err := readPeerFile(thisNode.conf.PeerCacheFile)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("[FAILURE] Could not read peer cache: ", err)
+		}
+		addresses = append(addresses, cached...)
+	}
+
+	ownAddress := net.JoinHostPort(thisNode.thisPeer.ip, strconv.Itoa(int(thisNode.thisPeer.port)))
+	seen := make(map[string]bool)
+	var result []peer
+	for _, address := range addresses {
+		p, err := extractPeerAddressFromString(address)
+		if err != nil {
+			log.Error("[FAILURE] Skipping bootstrap peer: ", err)
+			continue
+		}
+		normalized := net.JoinHostPort(p.ip, strconv.Itoa(int(p.port)))
+		if seen[normalized] || normalized == ownAddress {
+			continue
+		}
+		seen[normalized] = true
+		result = append(result, p)
+	}
+	return result
+}
+
+// reads a file with one ip:port address per line, empty lines and lines starting with # are ignored
+func readPeerFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result, scanner.Err()
+}
+
+// resolves the host of a host:port name to all of its A and AAAA records and returns them as ip:port addresses
+func resolveBootstrapDNS(name string) ([]string, error) {
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		return nil, errors.New("Wrong bootstrap DNS name " + name + ": " + err.Error())
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, net.JoinHostPort(ip, port))
+	}
+	return result, nil
+}
+
+// writes the addresses of all peers in the routing table to the peer cache file, so they can be used for bootstrapping
+// after a restart; the file is replaced atomically
+func (thisNode *Node) writePeerCache() error {
+	var content strings.Builder
+	content.WriteString("# peers known by " + thisNode.thisPeer.toString() + " at its last shutdown\n")
+	for _, p := range thisNode.knownPeers() {
+		content.WriteString(net.JoinHostPort(p.ip, strconv.Itoa(int(p.port))) + "\n")
+	}
+
+	tmpFile := thisNode.conf.PeerCacheFile + ".tmp"
+	err := os.WriteFile(tmpFile, []byte(content.String()), 0644)
+	if err != nil {
+		return errors.New("Could not write peer cache: " + err.Error())
+	}
+	err = os.Rename(tmpFile, thisNode.conf.PeerCacheFile)
+	if err != nil {
+		return errors.New("Could not write peer cache: " + err.Error())
+	}
+	log.Info("[SUCCESS] Wrote peer cache ", thisNode.conf.PeerCacheFile)
+	return nil
+}
@@ -0,0 +1,120 @@
+package dht
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test if bootstrap peers are collected from all sources without duplicates, malformed entries and the own address
+func TestBootstrapPeers(t *testing.T) {
+	dir := t.TempDir()
+	seedFile := filepath.Join(dir, "seeds")
+	err := os.WriteFile(seedFile, []byte("# seeds\n127.0.0.1:4001\n\n  [::1]:4002  \nnot-an-address\n127.0.0.1:4003\n"), 0644)
+	if err != nil {
+		t.Fatal("[FAILURE] could not write seed file: ", err)
+	}
+
+	testNode := buildTestNode("1", 4000)
+	testNode.conf.BootstrapPeers = []string{"127.0.0.1:4001", "127.0.0.1:4000", "127.0.0.1:4004"}
+	testNode.conf.SeedFile = seedFile
+	testNode.conf.BootstrapDNS = "localhost:4005"
+	// a missing peer cache is no error, it is written at the first shutdown
+	testNode.conf.PeerCacheFile = filepath.Join(dir, "peers.cache")
+
+	expected := map[peer]bool{
+		{ip: "127.0.0.1", port: 4001}: true,
+		{ip: "127.0.0.1", port: 4004}: true,
+		{ip: "::1", port: 4002}:       true,
+		{ip: "127.0.0.1", port: 4003}: true,
+		{ip: "127.0.0.1", port: 4005}: true,
+	}
+	result := testNode.bootstrapPeers()
+	found := make(map[peer]bool)
+	for _, p := range result {
+		if found[p] {
+			t.Errorf("[FAILURE] bootstrap peer %s is contained twice", p.toString())
+		}
+		found[p] = true
+		if p.port == 4000 {
+			t.Errorf("[FAILURE] own address must not be a bootstrap peer")
+		}
+	}
+	for p := range expected {
+		if !found[p] {
+			t.Errorf("[FAILURE] bootstrap peer %s is missing", p.toString())
+		}
+	}
+}
+
+// Test if the peers of the routing table are written to the peer cache and used for bootstrapping after a restart
+func TestPeerCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "peers.cache")
+
+	testNode := buildTestNode("1", 4010)
+	testNode.conf.PeerCacheFile = cacheFile
+	known := []peer{
+		{id: buildTestIdFromString("01"), ip: "127.0.0.1", port: 4011},
+		{id: buildTestIdFromString("001"), ip: "::1", port: 4012},
+	}
+	for _, p := range known {
+		testNode.updateRoutingTable(p)
+	}
+	if err := testNode.writePeerCache(); err != nil {
+		t.Fatal("[FAILURE] could not write peer cache: ", err)
+	}
+
+	restartedNode := buildTestNode("1", 4010)
+	restartedNode.conf.PeerCacheFile = cacheFile
+	result := restartedNode.bootstrapPeers()
+	if len(result) != len(known) {
+		t.Fatalf("[FAILURE] expected %d cached peers, got %d", len(known), len(result))
+	}
+	for i, p := range known {
+		// the ID of a cached peer is learned again from its KDM_PONG
+		if result[i].ip != p.ip || result[i].port != p.port {
+			t.Errorf("[FAILURE] cached peer %s was not restored", p.toString())
+		}
+	}
+}
+
+// Test if the bootstrap list is read from the ini file, including the former keys preConfPeer1 to preConfPeer3
+func TestLoadBootstrapOptions(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.ini")
+	err := os.WriteFile(configFile, []byte(`hostkey = config/mainHostkey.pem
+
+[dht]
+api_address = 127.0.0.1:3201
+p2p_address = [::1]:3202
+maxTTL = 86400
+preConfPeer1 = 127.0.0.1:3004
+bootstrapPeers = 127.0.0.1:3006, [::1]:3008
+seedFile = config/seeds
+peerCache = config/peers.cache
+k = 20
+a = 3
+`), 0644)
+	if err != nil {
+		t.Fatal("[FAILURE] could not write config file: ", err)
+	}
+
+	opts, err := LoadOptions(configFile)
+	if err != nil {
+		t.Fatal("[FAILURE] could not load config file: ", err)
+	}
+	expected := []string{"127.0.0.1:3004", "127.0.0.1:3006", "[::1]:3008"}
+	if len(opts.BootstrapPeers) != len(expected) {
+		t.Fatalf("[FAILURE] expected bootstrap peers %v, got %v", expected, opts.BootstrapPeers)
+	}
+	for i := range expected {
+		if opts.BootstrapPeers[i] != expected[i] {
+			t.Errorf("[FAILURE] expected bootstrap peers %v, got %v", expected, opts.BootstrapPeers)
+		}
+	}
+	if opts.P2PIP != "::1" || opts.P2PPort != 3202 {
+		t.Errorf("[FAILURE] IPv6 p2p_address was not parsed")
+	}
+	if opts.SeedFile != "config/seeds" || opts.PeerCacheFile != "config/peers.cache" || opts.BootstrapDNS != "" {
+		t.Errorf("[FAILURE] seedFile, peerCache or bootstrapDNS was not parsed")
+	}
+}
@@ -0,0 +1,119 @@
+package dht
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ini.v1"
+)
+
+// LoadOptions parses the ini configuration file at the given path
+func LoadOptions(pathToConfigFile string) (Options, error) {
+	config, err := ini.Load(pathToConfigFile)
+	if err != nil {
+		return Options{}, errors.New("Could not parse specified config file: " + err.Error())
+	}
+
+	tmpMaxTtl, err := config.Section("dht").Key("maxTTL").Int()
+	if err != nil {
+		return Options{}, errors.New("Wrong configuration: maxTTL is not an Integer")
+	}
+
+	k, err := config.Section("dht").Key("k").Int()
+	if err != nil {
+		return Options{}, errors.New("Wrong configuration: k is not an Integer")
+	}
+	a, err := config.Section("dht").Key("a").Int()
+	if err != nil {
+		return Options{}, errors.New("Wrong configuration: a is not an Integer")
+	}
+
+	// the bounds of the replication degree are optional, NewNode falls back to 1 and k
+	minReplication, maxReplication := 0, 0
+	if config.Section("dht").HasKey("minReplication") {
+		minReplication, err = config.Section("dht").Key("minReplication").Int()
+		if err != nil {
+			return Options{}, errors.New("Wrong configuration: minReplication is not an Integer")
+		}
+	}
+	if config.Section("dht").HasKey("maxReplication") {
+		maxReplication, err = config.Section("dht").Key("maxReplication").Int()
+		if err != nil {
+			return Options{}, errors.New("Wrong configuration: maxReplication is not an Integer")
+		}
+	}
+
+	// the refresh interval is optional as well, NewNode falls back to REFRESH_INTERVAL
+	refreshInterval := 0
+	if config.Section("dht").HasKey("refreshInterval") {
+		refreshInterval, err = config.Section("dht").Key("refreshInterval").Int()
+		if err != nil {
+			return Options{}, errors.New("Wrong configuration: refreshInterval is not an Integer")
+		}
+	}
+
+	// the puzzle difficulties are optional, the puzzles are disabled by default
+	staticPuzzleDifficulty, dynamicPuzzleDifficulty := 0, 0
+	if config.Section("dht").HasKey("staticPuzzleDifficulty") {
+		staticPuzzleDifficulty, err = config.Section("dht").Key("staticPuzzleDifficulty").Int()
+		if err != nil {
+			return Options{}, errors.New("Wrong configuration: staticPuzzleDifficulty is not an Integer")
+		}
+	}
+	if config.Section("dht").HasKey("dynamicPuzzleDifficulty") {
+		dynamicPuzzleDifficulty, err = config.Section("dht").Key("dynamicPuzzleDifficulty").Int()
+		if err != nil {
+			return Options{}, errors.New("Wrong configuration: dynamicPuzzleDifficulty is not an Integer")
+		}
+	}
+
+	// bootstrap peers are given as comma separated list, the former keys preConfPeer1 to preConfPeer3 still work
+	var bootstrapPeers []string
+	for _, name := range []string{"preConfPeer1", "preConfPeer2", "preConfPeer3"} {
+		if config.Section("dht").HasKey(name) {
+			bootstrapPeers = append(bootstrapPeers, config.Section("dht").Key(name).String())
+		}
+	}
+	if config.Section("dht").HasKey("bootstrapPeers") {
+		bootstrapPeers = append(bootstrapPeers, config.Section("dht").Key("bootstrapPeers").Strings(",")...)
+	}
+
+	apiAddr, err := extractPeerAddressFromString(config.Section("dht").Key("api_address").String())
+	if err != nil {
+		return Options{}, errors.New("Wrong configuration: api_address: " + err.Error())
+	}
+	p2pAddr, err := extractPeerAddressFromString(config.Section("dht").Key("p2p_address").String())
+	if err != nil {
+		return Options{}, errors.New("Wrong configuration: p2p_address: " + err.Error())
+	}
+
+	opts := Options{
+		HostKeyFile: config.Section("").Key("hostkey").String(),
+		APIIP:       apiAddr.ip,
+		APIPort:     apiAddr.port,
+		P2PIP:       p2pAddr.ip,
+		P2PPort:     p2pAddr.port,
+		MaxTTL:      tmpMaxTtl,
+		K:           k,
+		A:           a,
+
+		BootstrapPeers:   bootstrapPeers,
+		SeedFile:         config.Section("dht").Key("seedFile").String(),
+		BootstrapDNS:     config.Section("dht").Key("bootstrapDNS").String(),
+		PeerCacheFile:    config.Section("dht").Key("peerCache").String(),
+		StorageFile:      config.Section("dht").Key("storageFile").String(),
+		RoutingTableFile: config.Section("dht").Key("routingTableFile").String(),
+		Transport:        config.Section("dht").Key("transport").MustString(TRANSPORT_TCP),
+
+		MinReplication: minReplication,
+		MaxReplication: maxReplication,
+
+		RefreshInterval: refreshInterval,
+
+		StaticPuzzleDifficulty:  staticPuzzleDifficulty,
+		DynamicPuzzleDifficulty: dynamicPuzzleDifficulty,
+	}
+
+	log.Info("[SUCCESS] Read and Parsed the following Configuration file: ", opts.toString())
+	return opts, nil
+}
@@ -0,0 +1,56 @@
+package dht
+
+import (
+	"container/heap"
+	"time"
+)
+
+// deadline (expiration or republishing time) of the entry of key
+type deadline struct {
+	at  time.Time
+	key id
+}
+
+/*
+deadlineQueue is a min-heap of deadlines, the earliest deadline is always at index 0, so the due deadlines can be taken
+without looking at the others. When the deadline of an entry changes, the new one is simply added; the outdated one
+stays in the queue until it is due and has to be recognized as outdated by comparing it with the entry.
+*/
+type deadlineQueue []deadline
+
+func (queue deadlineQueue) Len() int           { return len(queue) }
+func (queue deadlineQueue) Less(i, j int) bool { return queue[i].at.Before(queue[j].at) }
+func (queue deadlineQueue) Swap(i, j int)      { queue[i], queue[j] = queue[j], queue[i] }
+
+func (queue *deadlineQueue) Push(x interface{}) {
+	*queue = append(*queue, x.(deadline))
+}
+
+func (queue *deadlineQueue) Pop() interface{} {
+	old := *queue
+	last := old[len(old)-1]
+	*queue = old[:len(old)-1]
+	return last
+}
+
+// adds the deadline at for the entry of key
+func (queue *deadlineQueue) schedule(key id, at time.Time) {
+	heap.Push(queue, deadline{at: at, key: key})
+}
+
+// removes and returns the earliest deadline if it lies before now, otherwise returns false
+func (queue *deadlineQueue) popDue(now time.Time) (deadline, bool) {
+	if len(*queue) == 0 || !now.After((*queue)[0].at) {
+		return deadline{}, false
+	}
+	return heap.Pop(queue).(deadline), true
+}
+
+// replaces the content of the queue with one deadline per entry, which drops all outdated deadlines
+func (queue *deadlineQueue) rebuild(entries map[id]storageEntry, deadlineOf func(storageEntry) time.Time) {
+	*queue = (*queue)[:0]
+	for key, entry := range entries {
+		*queue = append(*queue, deadline{at: deadlineOf(entry), key: key})
+	}
+	heap.Init(queue)
+}
@@ -0,0 +1,30 @@
+package dht
+
+import (
+	"testing"
+	"time"
+)
+
+// Test if due deadlines are taken in the order of their time and deadlines which are not due stay in the queue
+func TestDeadlineQueue(t *testing.T) {
+	now := time.Now()
+	var queue deadlineQueue
+	queue.schedule(buildTestIdFromString("01"), now.Add(2*time.Second))
+	queue.schedule(buildTestIdFromString("1"), now.Add(time.Second))
+	queue.schedule(buildTestIdFromString("001"), now.Add(3*time.Second))
+
+	first, ok := queue.popDue(now.Add(2500 * time.Millisecond))
+	if !ok || first.key != buildTestIdFromString("1") {
+		t.Errorf("[FAILURE] earliest deadline was not taken first")
+	}
+	second, ok := queue.popDue(now.Add(2500 * time.Millisecond))
+	if !ok || second.key != buildTestIdFromString("01") {
+		t.Errorf("[FAILURE] second deadline was not taken second")
+	}
+	if _, ok = queue.popDue(now.Add(2500 * time.Millisecond)); ok {
+		t.Errorf("[FAILURE] deadline which is not due was taken")
+	}
+	if len(queue) != 1 {
+		t.Errorf("[FAILURE] deadline which is not due was removed")
+	}
+}
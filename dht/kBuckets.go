@@ -0,0 +1,513 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const REFRESH_INTERVAL int = 3600 // refresh k-Buckets without lookup for 3600s
+
+type kBucket []peer
+
+// struct for routing table binary tree
+// if it has children, then kBucket has to be nil and vice versa
+type routingTree struct {
+	left    *routingTree
+	right   *routingTree
+	parent  *routingTree // nil if routingTree is root
+	prefix  string
+	k       int // maximum number of peers per k-Bucket
+	kBucket kBucket
+
+	// peers which were seen while the k-Bucket was full, least-recently seen at the beginning
+	// they replace peers of the k-Bucket which do not answer anymore
+	// only k-Buckets which are never split (not including the own id) have candidates
+	replacementCache kBucket
+	// set while the least-recently seen peer of the k-Bucket is pinged
+	pinging bool
+	// time of the last node lookup for an id in the range of the k-Bucket
+	lastLookup time.Time
+	// time of the last contact with each peer of the k-Bucket
+	lastSeen map[id]time.Time
+}
+
+func (r *routingTree) toString() string {
+	result := r.prefix
+	if r.kBucket != nil {
+		result = result + " has " + r.kBucket.toString() + "\n"
+	}
+	if r.left != nil {
+		result = result + "left:" + r.left.toString() + "\n"
+	}
+	if r.right != nil {
+
+		result = result + "right:" + r.right.toString() + "\n"
+	}
+	return result
+}
+
+// returns if kBucket contains id
+func (kBucket *kBucket) contains(id id) bool {
+
+	for _, element := range *kBucket {
+		if element.id == id {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+func (kBucket *kBucket) toString() string {
+	result := ""
+	for _, element := range *kBucket {
+		result = result + "    " + strconv.Itoa(int(element.port))
+	}
+
+	return result
+
+}
+
+// checks if k-Bucket of given routingTree node is responsible for given id
+func (routingTable *routingTree) inRange(id id) bool {
+	return id.startsWith(routingTable.prefix)
+}
+
+// returns index of id in k-Bucket or -1 if not containing
+func (kBucket *kBucket) indexOf(id id) int {
+
+	for i, element := range *kBucket {
+		if element.id == id {
+			return i
+		}
+	}
+
+	return -1
+
+}
+
+// moves id stored in k-Bucket to tail of k-Bucket
+func (kBucket *kBucket) moveToTail(id id) {
+
+	i := kBucket.indexOf(id)
+
+	if i != -1 {
+		tmp := (*kBucket)[i]
+		*kBucket = append((*kBucket)[:i], (*kBucket)[i+1:]...)
+		*kBucket = append(*kBucket, tmp)
+	}
+
+}
+
+// checks if k-Bucket of given routingTree node is full
+func (routingTable *routingTree) isFull() bool {
+	return len(routingTable.kBucket) == routingTable.maxSize()
+}
+
+// returns maximum size of k-Bucket of given routingTree node
+func (routingTable *routingTree) maxSize() int {
+
+	remainingBits := SIZE_OF_ID*8 - len(routingTable.prefix)
+	if remainingBits < routingTable.k { // roughly evict obvious cases
+		rangeLimit := math.Pow(2, float64(remainingBits))
+		if rangeLimit < float64(routingTable.k) {
+			return int(rangeLimit)
+		}
+	}
+
+	return routingTable.k
+
+}
+
+// finds responsible routingTree node for given id; routingTreeLock has to be held
+func (thisNode *Node) findResponsibleRoutingTree(key id) *routingTree {
+	var tmpTree = &thisNode.routingTree
+
+	for {
+		if tmpTree.kBucket != nil {
+			return tmpTree
+		} else {
+			var bitNumber = len(tmpTree.prefix) % 8
+			var byteNumber = len(tmpTree.prefix) / 8
+			var byte = key[byteNumber]
+			var bit = (byte & (128 >> bitNumber)) != 0
+
+			if !bit {
+				tmpTree = tmpTree.left
+			} else {
+				tmpTree = tmpTree.right
+			}
+
+		}
+	}
+}
+
+// inserts new peer into k-Bucket of given routingTree node
+func (routingTable *routingTree) insert(peer peer) {
+	// only insert if id of peer is not already in bucket and if bucket is not already full
+	if !routingTable.kBucket.contains(peer.id) && len(routingTable.kBucket) < routingTable.maxSize() {
+		routingTable.kBucket = append(routingTable.kBucket, peer)
+	}
+}
+
+// records that the peer with the given id was seen at the given time
+func (routingTable *routingTree) markSeen(peerID id, seen time.Time) {
+	if routingTable.lastSeen == nil {
+		routingTable.lastSeen = make(map[id]time.Time)
+	}
+	routingTable.lastSeen[peerID] = seen
+}
+
+// adds peer to the tail of the replacement cache of given routingTree node
+// if the cache is already full, the least-recently seen candidate is dropped
+func (routingTable *routingTree) addReplacement(peer peer) {
+	routingTable.replacementCache.remove(peer.id)
+	if len(routingTable.replacementCache) == routingTable.k {
+		routingTable.replacementCache = routingTable.replacementCache[1:]
+	}
+	routingTable.replacementCache = append(routingTable.replacementCache, peer)
+}
+
+// removes id from given k-Bucket
+func (kBucket *kBucket) remove(id id) {
+	i := kBucket.indexOf(id)
+	if i == -1 {
+		return
+	}
+	*kBucket = append((*kBucket)[:i], (*kBucket)[i+1:]...)
+}
+
+// splits given routingTree node into two new routingTree nodes
+func (routingTable *routingTree) split() error {
+
+	if len(routingTable.prefix) == SIZE_OF_ID*8 {
+		return errors.New("Tried to split k-Bucket with maximum size of 1")
+	}
+
+	log.Debug("splitting at current prefix ", routingTable.prefix)
+	prefixLeft := routingTable.prefix + "0"
+	prefixRight := routingTable.prefix + "1"
+
+	routingTreeLeft := routingTree{prefix: prefixLeft, parent: routingTable, k: routingTable.k, kBucket: kBucket{}, lastLookup: routingTable.lastLookup}
+	routingTreeRight := routingTree{prefix: prefixRight, parent: routingTable, k: routingTable.k, kBucket: kBucket{}, lastLookup: routingTable.lastLookup}
+
+	routingTable.left = &routingTreeLeft
+	routingTable.right = &routingTreeRight
+
+	for _, element := range routingTable.kBucket {
+		child := routingTable.right
+		if element.id.startsWith(prefixLeft) {
+			child = routingTable.left
+		}
+		child.insert(element)
+		if seen, ok := routingTable.lastSeen[element.id]; ok {
+			child.markSeen(element.id, seen)
+		}
+	}
+
+	// reset kBucket of routingTree node
+	routingTable.kBucket = nil
+	routingTable.lastSeen = nil
+
+	return nil
+
+}
+
+// returns the corresponding sibling (left child if routingTree is right child and vice versa)
+// or nil if current routingTree is root
+func (routingTable *routingTree) getSibling() *routingTree {
+	if routingTable.parent == nil {
+		return nil
+	}
+	if routingTable.parent.left == routingTable {
+		return routingTable.parent.right
+	} else {
+		return routingTable.parent.left
+	}
+}
+
+//returns a number of the closest peers in a given bucket to a given id ordered by distance (closest at the beginning)
+func (kBucket *kBucket) findNumberOfClosestPeersInOneBucket(key id, number int) []peer {
+	result := make([]peer, 0, number)
+
+	for i := 0; i < len(*kBucket); i++ {
+		if len(result) < number {
+			result = append(result, (*kBucket)[i])
+		} else {
+			indexOfFarest := findIndexOfFarestPeerInSlice(result, key)
+			dNew := distance(key, (*kBucket)[i].id)
+			dOld := distance(key, result[indexOfFarest].id)
+			if bytes.Compare(dNew[:], dOld[:]) < 0 {
+				result[indexOfFarest] = (*kBucket)[i]
+			}
+		}
+	}
+
+	// sort by distance
+	sort.Slice(result, func(i, j int) bool {
+		dI := distance(key, result[i].id)
+		dJ := distance(key, result[j].id)
+		return bytes.Compare(dI[:], dJ[:]) < 0
+	})
+	return result
+}
+
+// returns given number of closest peers to given id available in subtree with given routingTree node as root node
+func (routingTable *routingTree) getNumberOfClosestPeers(key id, number int) []peer {
+	if routingTable.kBucket != nil {
+		return routingTable.kBucket.findNumberOfClosestPeersInOneBucket(key, number)
+	} else {
+		tmp := kBucket{}
+		tmp = append(tmp, routingTable.left.getNumberOfClosestPeers(key, number)...)
+		tmp = append(tmp, routingTable.right.getNumberOfClosestPeers(key, number)...)
+		return tmp.findNumberOfClosestPeersInOneBucket(key, number)
+	}
+}
+
+//returns index of the peer from a slice of peers that is the farest away from a given id
+func findIndexOfFarestPeerInSlice(peers []peer, key id) int {
+	var maxDistance [SIZE_OF_ID]byte
+	index := -1
+	for i := 0; i < len(peers); i++ {
+		d := distance(key, peers[i].id)
+		if bytes.Compare(d[:], maxDistance[:]) > 0 {
+			maxDistance = d
+			index = i
+		}
+	}
+	return index
+}
+
+//returns a specified amount of peers that are the closest to a specified id on a node
+func (thisNode *Node) findNumberOfClosestPeersOnNode(key id, number int) []peer {
+	thisNode.routingTreeLock.RLock()
+	defer thisNode.routingTreeLock.RUnlock()
+	responsibleBucket := thisNode.findResponsibleRoutingTree(key)
+
+	for {
+		result := responsibleBucket.getNumberOfClosestPeers(key, number)
+		if len(result) == number || responsibleBucket.parent == nil {
+			return result
+		} else {
+			responsibleBucket = responsibleBucket.parent
+		}
+	}
+}
+
+/*
+updateRoutingTable updates the routingTable of the local node when it made contact with the given peer. It is safe for
+concurrent use and never blocks on the network: if the responsible k-Bucket is full and can not be split, the peer is
+put into the replacement cache of the k-Bucket and the least-recently seen peer is pinged in the background. Peers
+whose id does not solve the static puzzle are never inserted.
+*/
+func (thisNode *Node) updateRoutingTable(p peer) {
+	if p.id == thisNode.thisPeer.id {
+		return // the local peer is never stored in its own routing table
+	}
+	if !solvesStaticPuzzle(p.id, thisNode.conf.StaticPuzzleDifficulty) {
+		return
+	}
+
+	thisNode.routingTreeLock.Lock()
+	known := thisNode.findResponsibleRoutingTree(p.id).kBucket.contains(p.id)
+	leastRecentlySeen, pingNeeded := thisNode.insertIntoRoutingTree(p)
+	inserted := !known && thisNode.findResponsibleRoutingTree(p.id).kBucket.contains(p.id)
+	thisNode.routingTreeLock.Unlock()
+
+	// the ping is tracked by the wait group of the node, so Close waits for it
+	if pingNeeded {
+		thisNode.wg.Add(1)
+		go func() {
+			defer thisNode.wg.Done()
+			thisNode.checkLeastRecentlySeen(leastRecentlySeen)
+		}()
+	}
+	// a peer which just entered the routing table receives the keys it is now responsible for
+	if inserted {
+		thisNode.scheduleReplication(p)
+	}
+}
+
+// inserts peer into the responsible k-Bucket, splitting k-Buckets if necessary; routingTreeLock has to be held
+// if the peer ends up in the replacement cache and the least-recently seen peer of the k-Bucket has to be pinged,
+// this peer is returned together with true
+func (thisNode *Node) insertIntoRoutingTree(p peer) (peer, bool) {
+	for {
+		// find responsible k-Bucket
+		routingTree := thisNode.findResponsibleRoutingTree(p.id)
+
+		// if peer already exists in k-Bucket, move it to the tail of the list
+		if routingTree.kBucket.contains(p.id) {
+			routingTree.kBucket.moveToTail(p.id)
+			routingTree.markSeen(p.id, time.Now())
+			return peer{}, false
+		}
+		// if k-Bucket is not already full, insert peer
+		if !routingTree.isFull() {
+			routingTree.insert(p)
+			routingTree.markSeen(p.id, time.Now())
+			return peer{}, false
+		}
+		// if range of k-Bucket includes own id, split bucket and repeat insertion attempt
+		if routingTree.inRange(thisNode.thisPeer.id) {
+			err := routingTree.split()
+			if err != nil {
+				return peer{}, false // abort update process
+			}
+			continue
+		}
+		// else keep peer as candidate and ping least-recently seen node, unless a ping is already running or the node
+		// is closing, as Close might already be waiting for the pings in progress
+		routingTree.addReplacement(p)
+		if routingTree.pinging || thisNode.closing() {
+			return peer{}, false
+		}
+		routingTree.pinging = true
+		return routingTree.kBucket[0], true
+	}
+}
+
+/*
+checkLeastRecentlySeen pings the least-recently seen peer of a full k-Bucket. If it answers, it is moved to the tail of
+the k-Bucket. Otherwise it is discarded and the most-recently seen candidate of the replacement cache takes its place.
+If the node started closing in the meantime, the k-Bucket is left unchanged.
+*/
+func (thisNode *Node) checkLeastRecentlySeen(leastRecentlySeen peer) {
+	nodeActive := thisNode.pingNode(leastRecentlySeen)
+
+	thisNode.routingTreeLock.Lock()
+	defer thisNode.routingTreeLock.Unlock()
+	// k-Buckets which do not include the own id are never split, so this is still the pinged k-Bucket
+	routingTree := thisNode.findResponsibleRoutingTree(leastRecentlySeen.id)
+	routingTree.pinging = false
+	if thisNode.closing() || !routingTree.kBucket.contains(leastRecentlySeen.id) {
+		return
+	}
+
+	if nodeActive {
+		// if node is active, keep it and move it to the tail
+		routingTree.kBucket.moveToTail(leastRecentlySeen.id)
+		routingTree.markSeen(leastRecentlySeen.id, time.Now())
+		return
+	}
+	// if node is inactive, discard it and promote the most-recently seen candidate
+	routingTree.kBucket.remove(leastRecentlySeen.id)
+	delete(routingTree.lastSeen, leastRecentlySeen.id)
+	if len(routingTree.replacementCache) > 0 {
+		last := len(routingTree.replacementCache) - 1
+		promoted := routingTree.replacementCache[last]
+		routingTree.insert(promoted)
+		routingTree.markSeen(promoted.id, time.Now())
+		routingTree.replacementCache = routingTree.replacementCache[:last]
+		thisNode.scheduleReplication(promoted)
+	}
+}
+
+// marks the k-Bucket responsible for key as looked up
+func (thisNode *Node) touchRoutingTree(key id) {
+	thisNode.routingTreeLock.Lock()
+	defer thisNode.routingTreeLock.Unlock()
+	thisNode.findResponsibleRoutingTree(key).lastLookup = time.Now()
+}
+
+// appends the prefixes of all k-Buckets in the subtree without lookup since deadline to result
+// these k-Buckets are marked as looked up, so they are refreshed only once
+func (routingTable *routingTree) collectStaleBuckets(deadline time.Time, result *[]string) {
+	if routingTable.kBucket == nil {
+		routingTable.left.collectStaleBuckets(deadline, result)
+		routingTable.right.collectStaleBuckets(deadline, result)
+		return
+	}
+	if routingTable.lastLookup.Before(deadline) {
+		*result = append(*result, routingTable.prefix)
+		routingTable.lastLookup = time.Now()
+	}
+}
+
+// returns the prefixes of all k-Buckets of the local node which were not looked up during the refresh interval
+func (thisNode *Node) staleBuckets() []string {
+	thisNode.routingTreeLock.Lock()
+	defer thisNode.routingTreeLock.Unlock()
+	var result []string
+	deadline := time.Now().Add(-time.Duration(thisNode.conf.RefreshInterval) * time.Second)
+	thisNode.routingTree.collectStaleBuckets(deadline, &result)
+	return result
+}
+
+// refreshes the k-Buckets with the given prefixes by a node lookup for a random id in their range
+func (thisNode *Node) refreshBuckets(prefixes []string) {
+	for _, prefix := range prefixes {
+		log.Debug(thisNode.thisPeer.port, " refreshes k-Bucket with prefix ", prefix)
+		thisNode.nodeLookup(randomIdWithPrefix(prefix), false, thisNode.conf.K)
+	}
+}
+
+// returns the prefixes of all id ranges farther away from the own id than neighbor
+// these are the ranges of ids which share a shorter common prefix with the own id than neighbor does
+func (thisNode *Node) prefixesFartherThan(neighbor id) []string {
+	var result []string
+	prefix := ""
+	for i := 0; i < SIZE_OF_ID*8; i++ {
+		ownBit := thisNode.thisPeer.id[i/8]&(128>>(i%8)) != 0
+		neighborBit := neighbor[i/8]&(128>>(i%8)) != 0
+		if ownBit != neighborBit {
+			break
+		}
+		if ownBit {
+			result = append(result, prefix+"0")
+			prefix += "1"
+		} else {
+			result = append(result, prefix+"1")
+			prefix += "0"
+		}
+	}
+	return result
+}
+
+// returns a random id which starts with the given prefix of 0 and 1
+func randomIdWithPrefix(prefix string) id {
+	var result id
+	if _, err := rand.Read(result[:]); err != nil {
+		panic(err.Error())
+	}
+	for i, bit := range prefix {
+		if bit == '1' {
+			result[i/8] |= 128 >> (i % 8)
+		} else {
+			result[i/8] &^= 128 >> (i % 8)
+		}
+	}
+	return result
+}
+
+// returns all peers stored in the routing table of the local node
+func (thisNode *Node) knownPeers() []peer {
+	thisNode.routingTreeLock.RLock()
+	defer thisNode.routingTreeLock.RUnlock()
+	var result []peer
+	var collect func(routingTable *routingTree)
+	collect = func(routingTable *routingTree) {
+		if routingTable.kBucket == nil {
+			collect(routingTable.left)
+			collect(routingTable.right)
+			return
+		}
+		result = append(result, routingTable.kBucket...)
+	}
+	collect(&thisNode.routingTree)
+	return result
+}
+
+// returns a printable representation of the routing tree of the local node
+func (thisNode *Node) routingTableToString() string {
+	thisNode.routingTreeLock.RLock()
+	defer thisNode.routingTreeLock.RUnlock()
+	return thisNode.routingTree.toString()
+}
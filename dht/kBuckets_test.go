@@ -1,9 +1,13 @@
-package main
+package dht
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"net"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestContains(t *testing.T) {
@@ -76,14 +80,11 @@ func TestMoveToTail(t *testing.T) {
 }
 
 func TestMaxSize(t *testing.T) {
-	// init Conf
-	Conf.k = 5
-
 	// init empty routingTree
 	routingTree := buildEmptyTestRoutingTree()
 
-	if routingTree.maxSize() != Conf.k {
-		t.Errorf("[FAILURE] maxSize of root should be Conf.k")
+	if routingTree.maxSize() != routingTree.k {
+		t.Errorf("[FAILURE] maxSize of root should be k")
 	}
 
 	tmpPrefix := ""
@@ -242,14 +243,12 @@ func TestFindNumberOfClosestPeersInOneBucket(t *testing.T) {
 }
 
 func TestGetNumberOfClosestPeersOnNode(t *testing.T) {
-	// init Conf
-	Conf.k = 5
-
 	// init empty routingTree
 	routingTree := buildEmptyTestRoutingTree()
 
 	// init localNode
-	thisNode := localNode{routingTree: *routingTree}
+	thisNode := Node{routingTree: *routingTree, hashTable: hashTable{storage: newMemoryStorage()}}
+	setTestHostKey(&thisNode, "0000")
 	testPeer1 := peer{id: buildTestIdFromString("0001")}
 	testPeer2 := peer{id: buildTestIdFromString("0010")}
 	testPeer3 := peer{id: buildTestIdFromString("0011")}
@@ -280,9 +279,13 @@ func TestGetNumberOfClosestPeersOnNode(t *testing.T) {
 	thisNode.updateRoutingTable(testPeer10)
 	thisNode.updateRoutingTable(testPeer11)
 	thisNode.updateRoutingTable(testPeer12)
+	// the least-recently seen peers do not answer and are replaced one after another
 	thisNode.updateRoutingTable(testPeer13)
+	waitForPendingPings(t, &thisNode)
 	thisNode.updateRoutingTable(testPeer14)
+	waitForPendingPings(t, &thisNode)
 	thisNode.updateRoutingTable(testPeer15)
+	waitForPendingPings(t, &thisNode)
 
 	// findNumberOfClosestPeersOnNode(...) searching for own id (left subtree in this case) should return the closest peers existing
 	result := thisNode.findNumberOfClosestPeersOnNode(thisNode.thisPeer.id, 3)
@@ -323,14 +326,12 @@ func TestGetNumberOfClosestPeersOnNode(t *testing.T) {
 }
 
 func TestGetNumberOfClosestPeers(t *testing.T) {
-	// init Conf
-	Conf.k = 5
-
 	// init empty routingTree
 	routingTree := buildEmptyTestRoutingTree()
 
 	// init localNode
-	thisNode := localNode{routingTree: *routingTree}
+	thisNode := Node{routingTree: *routingTree, hashTable: hashTable{storage: newMemoryStorage()}}
+	setTestHostKey(&thisNode, "0000")
 	testPeer1 := peer{id: buildTestIdFromString("0001")}
 	testPeer2 := peer{id: buildTestIdFromString("0010")}
 	testPeer3 := peer{id: buildTestIdFromString("0011")}
@@ -361,9 +362,13 @@ func TestGetNumberOfClosestPeers(t *testing.T) {
 	thisNode.updateRoutingTable(testPeer10)
 	thisNode.updateRoutingTable(testPeer11)
 	thisNode.updateRoutingTable(testPeer12)
+	// the least-recently seen peers do not answer and are replaced one after another
 	thisNode.updateRoutingTable(testPeer13)
+	waitForPendingPings(t, &thisNode)
 	thisNode.updateRoutingTable(testPeer14)
+	waitForPendingPings(t, &thisNode)
 	thisNode.updateRoutingTable(testPeer15)
+	waitForPendingPings(t, &thisNode)
 
 	// getNumberOfClosestPeers(...) of root routingTree searching for own id (left subtree in this case) should return the closest peers existing
 	result := thisNode.routingTree.getNumberOfClosestPeers(thisNode.thisPeer.id, 3)
@@ -404,14 +409,12 @@ func TestGetNumberOfClosestPeers(t *testing.T) {
 }
 
 func TestUpdateInsertAndSplit(t *testing.T) {
-	// init Conf
-	Conf.k = 5
-
 	// init empty routingTree
 	routingTree := buildEmptyTestRoutingTree()
 
 	// init localNode
-	thisNode := localNode{routingTree: *routingTree}
+	thisNode := Node{routingTree: *routingTree, hashTable: hashTable{storage: newMemoryStorage()}}
+	setTestHostKey(&thisNode, "0000")
 	testPeer1 := peer{id: buildTestIdFromString("0001")}
 	testPeer2 := peer{id: buildTestIdFromString("0010")}
 	testPeer3 := peer{id: buildTestIdFromString("0011")}
@@ -445,6 +448,7 @@ func TestUpdateInsertAndSplit(t *testing.T) {
 	thisNode.updateRoutingTable(testPeer13)
 	thisNode.updateRoutingTable(testPeer14)
 	thisNode.updateRoutingTable(testPeer15)
+	waitForPendingPings(t, &thisNode)
 
 	failed := false
 
@@ -495,21 +499,16 @@ func TestUpdateInsertAndSplit(t *testing.T) {
 
 func TestUpdateInsertAndPing(t *testing.T) {
 
-	// init Conf
-	Conf.k = 5
-	Conf.p2pIP = "0.0.0.0"
-	Conf.p2pPort = 9999
-	Conf.HostKeyFile = "config/mainHostkey.pem"
-	Conf.preConfPeer1 = "127.0.0.1:3004"
-	Conf.preConfPeer2 = "127.0.0.1:3006"
-	Conf.preConfPeer3 = "127.0.0.1:3008"
-
 	// init empty routingTree
 	routingTree := buildEmptyTestRoutingTree()
 
 	// init localNode
-	thisNode := localNode{routingTree: *routingTree}
-	thisNode.thisPeer = peer{id: buildTestIdFromString("0"), port: 9999, ip: "0.0.0.0"} // own id only 0s
+	thisNode := Node{routingTree: *routingTree, hashTable: hashTable{storage: newMemoryStorage()}}
+	// own id starts with 0000 like no test peer, so the routing tree is the same as for an id of only 0s
+	// it belongs to a host key, as the ping of testPeer9 has to be signed
+	hostKey, publicKey, ownID := buildTestHostKey("0000")
+	thisNode.hostKey, thisNode.publicKey = hostKey, publicKey
+	thisNode.thisPeer = peer{id: ownID, port: 9999, ip: "0.0.0.0"}
 
 	testPeer1 := peer{id: buildTestIdFromString("0001")}
 	testPeer2 := peer{id: buildTestIdFromString("0010")}
@@ -528,14 +527,25 @@ func TestUpdateInsertAndPing(t *testing.T) {
 	testPeer15 := peer{id: buildTestIdFromString("1111")}
 
 	// make testPeer9 active
-
-	initializeP2PCommunication()
-	var wg sync.WaitGroup
-	ctx, cancelFunction := context.WithCancel(context.Background())
-	wg.Add(1)
-	Conf.p2pPort = testPeer9.port
-	Conf.p2pIP = testPeer9.ip
-	go startP2PMessageDispatcher(&wg, ctx)
+	activeNode, err := NewNode(Options{
+		HostKeyFile: "../config/mainHostkey.pem",
+		APIIP:       testPeer9.ip,
+		APIPort:     testPeer9.port + 1,
+		P2PIP:       testPeer9.ip,
+		P2PPort:     testPeer9.port,
+		MaxTTL:      86400,
+		K:           5,
+		A:           3,
+
+		BootstrapPeers: []string{"127.0.0.1:3004", "127.0.0.1:3006", "127.0.0.1:3008"},
+	})
+	if err != nil {
+		t.Fatal("[FAILURE] could not create node: ", err)
+	}
+	err = activeNode.Start(context.Background())
+	if err != nil && err != ErrNoBootstrapPeer {
+		t.Fatal("[FAILURE] could not start node: ", err)
+	}
 
 	thisNode.updateRoutingTable(testPeer1)
 	thisNode.updateRoutingTable(testPeer2)
@@ -550,8 +560,11 @@ func TestUpdateInsertAndPing(t *testing.T) {
 	thisNode.updateRoutingTable(testPeer11)
 	thisNode.updateRoutingTable(testPeer12)
 	thisNode.updateRoutingTable(testPeer13)
+	waitForPendingPings(t, &thisNode)
 	thisNode.updateRoutingTable(testPeer14)
+	waitForPendingPings(t, &thisNode)
 	thisNode.updateRoutingTable(testPeer15)
+	waitForPendingPings(t, &thisNode)
 
 	failed := false
 
@@ -598,8 +611,281 @@ func TestUpdateInsertAndPing(t *testing.T) {
 		t.Errorf("[Failure] routing tree has not the expected structure")
 	}
 
-	cancelFunction()
+	activeNode.Close()
+}
+
+/*
+TestConcurrentRoutingTableAccess inserts random peers from several goroutines while others query the closest peers of
+random keys, so buckets are split and full buckets trigger pings in parallel to the reads. Run it with -race to detect
+unsynchronized accesses. Afterwards the routing tree has to be consistent.
+*/
+func TestConcurrentRoutingTableAccess(t *testing.T) {
+	thisNode := buildTestNode("0", 8010)
+	// nobody listens on port 1, so every ping of a least-recently seen peer fails quickly
+	randomPeer := func() peer {
+		p := peer{ip: "127.0.0.1", port: 1}
+		if _, err := rand.Read(p.id[:]); err != nil {
+			panic(err.Error())
+		}
+		return p
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				thisNode.updateRoutingTable(randomPeer())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := randomPeer().id
+				result := thisNode.findNumberOfClosestPeersOnNode(key, thisNode.conf.K)
+				for l := 1; l < len(result); l++ {
+					dPrevious := distance(key, result[l-1].id)
+					d := distance(key, result[l].id)
+					if bytes.Compare(dPrevious[:], d[:]) >= 0 {
+						t.Errorf("[FAILURE] closest peers are not ordered by distance")
+					}
+				}
+				thisNode.routingTableToString()
+			}
+		}()
+	}
 	wg.Wait()
+
+	// every peer has to be stored in a k-Bucket of a leaf which is responsible for it, without exceeding k
+	var check func(routingTable *routingTree) int
+	check = func(routingTable *routingTree) int {
+		if routingTable.kBucket == nil {
+			return check(routingTable.left) + check(routingTable.right)
+		}
+		if len(routingTable.kBucket) > routingTable.maxSize() {
+			t.Errorf("[FAILURE] k-Bucket with prefix %s holds %d peers", routingTable.prefix, len(routingTable.kBucket))
+		}
+		for i, p := range routingTable.kBucket {
+			if !routingTable.inRange(p.id) {
+				t.Errorf("[FAILURE] peer stored in k-Bucket with wrong prefix %s", routingTable.prefix)
+			}
+			if routingTable.kBucket.indexOf(p.id) != i {
+				t.Errorf("[FAILURE] peer stored twice in k-Bucket with prefix %s", routingTable.prefix)
+			}
+		}
+		return len(routingTable.kBucket)
+	}
+	// pings of least-recently seen peers may still be running
+	thisNode.routingTreeLock.RLock()
+	defer thisNode.routingTreeLock.RUnlock()
+	if check(&thisNode.routingTree) == 0 {
+		t.Errorf("[FAILURE] no peer was inserted")
+	}
+}
+
+func TestAddReplacement(t *testing.T) {
+	routingTree := buildEmptyTestRoutingTree()
+	testPeers := []peer{}
+	for _, prefix := range []string{"001", "010", "011", "100", "101", "110", "111"} {
+		testPeers = append(testPeers, peer{id: buildTestIdFromString(prefix)})
+	}
+
+	for _, p := range testPeers {
+		routingTree.addReplacement(p)
+	}
+	// only the k most-recently seen candidates are kept
+	if len(routingTree.replacementCache) != routingTree.k || routingTree.replacementCache[0] != testPeers[2] {
+		t.Errorf("[FAILURE] replacement cache does not drop the least-recently seen candidates")
+	}
+
+	// a candidate seen again is moved to the tail
+	routingTree.addReplacement(testPeers[2])
+	if len(routingTree.replacementCache) != routingTree.k || routingTree.replacementCache[routingTree.k-1] != testPeers[2] {
+		t.Errorf("[FAILURE] candidate seen again was not moved to the tail of the replacement cache")
+	}
+}
+
+/*
+TestUpdateWithReplacementCache fills the k-Bucket with prefix 1 and checks that further peers are put into its
+replacement cache without waiting for the ping of the least-recently seen peer. A least-recently seen peer which does
+not answer is replaced by the newest candidate, one which answers is kept. A closing node does not ping anymore.
+*/
+func TestUpdateWithReplacementCache(t *testing.T) {
+	thisNode := buildTestNode("0", 8011)
+
+	// peer which accepts connections but never answers, so its ping times out
+	silentListener, err := net.Listen("tcp", "127.0.0.1:8012")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	defer silentListener.Close()
+	go func() {
+		for {
+			conn, err := silentListener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	silentPeer := peer{id: buildTestIdFromString("1000"), ip: "127.0.0.1", port: 8012}
+
+	// peer which answers pings
+	activeNode := buildTestNode("1111", 8013)
+	activeListener, err := net.Listen("tcp", "127.0.0.1:8013")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	defer activeListener.Close()
+	go func() {
+		for {
+			conn, err := activeListener.Accept()
+			if err != nil {
+				return
+			}
+			go activeNode.handleP2PConnection(conn)
+		}
+	}()
+
+	thisNode.updateRoutingTable(silentPeer)
+	thisNode.updateRoutingTable(activeNode.thisPeer)
+	thisNode.updateRoutingTable(peer{id: buildTestIdFromString("1001"), ip: "127.0.0.1", port: 1})
+	thisNode.updateRoutingTable(peer{id: buildTestIdFromString("1010"), ip: "127.0.0.1", port: 1})
+	thisNode.updateRoutingTable(peer{id: buildTestIdFromString("1011"), ip: "127.0.0.1", port: 1})
+	candidate1 := peer{id: buildTestIdFromString("1100"), ip: "127.0.0.1", port: 1}
+	candidate2 := peer{id: buildTestIdFromString("1101"), ip: "127.0.0.1", port: 1}
+
+	// the update must not wait for the ping of the silent peer
+	start := time.Now()
+	thisNode.updateRoutingTable(candidate1)
+	if time.Since(start) >= REQUEST_TIMEOUT {
+		t.Errorf("[FAILURE] updateRoutingTable blocked while pinging the least-recently seen peer")
+	}
+	thisNode.routingTreeLock.RLock()
+	bucket := thisNode.findResponsibleRoutingTree(candidate1.id)
+	if bucket.kBucket.contains(candidate1.id) || !bucket.replacementCache.contains(candidate1.id) || !bucket.pinging {
+		t.Errorf("[FAILURE] new peer has to wait in the replacement cache while the least-recently seen peer is pinged")
+	}
+	thisNode.routingTreeLock.RUnlock()
+
+	// the silent peer is replaced by the candidate
+	waitForPendingPings(t, thisNode)
+	thisNode.routingTreeLock.RLock()
+	if bucket.kBucket.contains(silentPeer.id) || bucket.kBucket[len(bucket.kBucket)-1] != candidate1 || len(bucket.replacementCache) != 0 {
+		t.Errorf("[FAILURE] inactive least-recently seen peer was not replaced by the candidate")
+	}
+	thisNode.routingTreeLock.RUnlock()
+
+	// the active peer is kept and moved to the tail, the candidate stays in the replacement cache
+	thisNode.updateRoutingTable(candidate2)
+	waitForPendingPings(t, thisNode)
+	thisNode.routingTreeLock.RLock()
+	if bucket.kBucket[len(bucket.kBucket)-1] != activeNode.thisPeer || bucket.kBucket.contains(candidate2.id) ||
+		!bucket.replacementCache.contains(candidate2.id) {
+		t.Errorf("[FAILURE] active least-recently seen peer was not kept")
+	}
+	thisNode.routingTreeLock.RUnlock()
+
+	// a closing node does not ping anymore, so the unreachable least-recently seen peer is kept
+	thisNode.wg.Wait()
+	thisNode.ctx, thisNode.cancel = context.WithCancel(context.Background())
+	thisNode.cancel()
+	thisNode.routingTreeLock.RLock()
+	leastRecentlySeen := bucket.kBucket[0]
+	thisNode.routingTreeLock.RUnlock()
+	thisNode.updateRoutingTable(peer{id: buildTestIdFromString("1110"), ip: "127.0.0.1", port: 1})
+	thisNode.wg.Wait()
+	waitForPendingPings(t, thisNode)
+	thisNode.routingTreeLock.RLock()
+	if !bucket.kBucket.contains(leastRecentlySeen.id) {
+		t.Errorf("[FAILURE] closing node pinged the least-recently seen peer and changed the k-Bucket")
+	}
+	thisNode.routingTreeLock.RUnlock()
+}
+
+func TestRandomIdWithPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "1", "0110", "101010101", "0000000000000000011"} {
+		for i := 0; i < 10; i++ {
+			randomId := randomIdWithPrefix(prefix)
+			if !randomId.startsWith(prefix) {
+				t.Errorf("[FAILURE] random id does not start with prefix %s", prefix)
+			}
+		}
+	}
+}
+
+func TestPrefixesFartherThan(t *testing.T) {
+	thisNode := Node{thisPeer: peer{id: buildTestIdFromString("0110")}}
+
+	prefixes := thisNode.prefixesFartherThan(buildTestIdFromString("0111"))
+	expected := []string{"1", "00", "010"}
+	if len(prefixes) != len(expected) {
+		t.Fatalf("[FAILURE] expected prefixes %v, got %v", expected, prefixes)
+	}
+	for i := range expected {
+		if prefixes[i] != expected[i] {
+			t.Errorf("[FAILURE] expected prefixes %v, got %v", expected, prefixes)
+		}
+	}
+
+	if len(thisNode.prefixesFartherThan(buildTestIdFromString("1"))) != 0 {
+		t.Errorf("[FAILURE] no id range is farther away than a neighbor in the other half of the id space")
+	}
+}
+
+func TestStaleBuckets(t *testing.T) {
+	thisNode := buildTestNode("0", 8014)
+	thisNode.conf.RefreshInterval = 3600
+	thisNode.routingTree.lastLookup = time.Now()
+	if err := thisNode.routingTree.split(); err != nil {
+		t.Fatal("[FAILURE] could not split routing tree: ", err)
+	}
+	if err := thisNode.routingTree.left.split(); err != nil {
+		t.Fatal("[FAILURE] could not split routing tree: ", err)
+	}
+
+	// new k-Buckets inherit the last lookup of their parent
+	if len(thisNode.staleBuckets()) != 0 {
+		t.Errorf("[FAILURE] recently looked up k-Buckets must not be refreshed")
+	}
+
+	thisNode.routingTree.right.lastLookup = time.Now().Add(-2 * time.Hour)
+	thisNode.routingTree.left.left.lastLookup = time.Now().Add(-2 * time.Hour)
+	// a lookup marks the responsible k-Bucket as looked up
+	thisNode.touchRoutingTree(buildTestIdFromString("001"))
+
+	stale := thisNode.staleBuckets()
+	if len(stale) != 1 || stale[0] != "1" {
+		t.Errorf("[FAILURE] only the k-Bucket with prefix 1 has to be refreshed, got %v", stale)
+	}
+	// a k-Bucket is refreshed only once per refresh interval
+	if len(thisNode.staleBuckets()) != 0 {
+		t.Errorf("[FAILURE] k-Bucket was refreshed twice")
+	}
+}
+
+// waits until all pings of least-recently seen peers started by updateRoutingTable are finished
+func waitForPendingPings(t *testing.T, thisNode *Node) {
+	var pinging func(routingTable *routingTree) bool
+	pinging = func(routingTable *routingTree) bool {
+		if routingTable.kBucket == nil {
+			return pinging(routingTable.left) || pinging(routingTable.right)
+		}
+		return routingTable.pinging
+	}
+
+	deadline := time.Now().Add(2 * REQUEST_TIMEOUT)
+	for time.Now().Before(deadline) {
+		thisNode.routingTreeLock.RLock()
+		running := pinging(&thisNode.routingTree)
+		thisNode.routingTreeLock.RUnlock()
+		if !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("[FAILURE] pings of least-recently seen peers did not finish")
 }
 
 func buildEmptyTestRoutingTree() *routingTree {
@@ -608,12 +894,21 @@ func buildEmptyTestRoutingTree() *routingTree {
 		right:   nil,
 		parent:  nil,
 		prefix:  "",
+		k:       5,
 		kBucket: kBucket{},
 	}
 
 	return &result
 }
 
+// gives thisNode a host key whose id starts with the given prefix, so the pings of full k-Buckets are signed
+// with the prefix 0000, which no test peer has, the routing tree is the same as for an id of only 0s
+func setTestHostKey(thisNode *Node, prefix string) {
+	hostKey, publicKey, ownID := buildTestHostKey(prefix)
+	thisNode.hostKey, thisNode.publicKey = hostKey, publicKey
+	thisNode.thisPeer.id = ownID
+}
+
 // takes a string of 0 and 1 and converts it to id
 // chars other than 0 or 1 are replaced by 0s
 // longer strings than id size are shortened and shorter strings are filled up with trailing 0s
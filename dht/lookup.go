@@ -0,0 +1,272 @@
+package dht
+
+import (
+	"bytes"
+	"math/bits"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// states of a peer in the shortlist of a node lookup
+const (
+	lookupCandidate = iota // known but not yet queried
+	lookupQueried          // request was sent, answer outstanding
+	lookupResponded        // answered the request
+	lookupFailed           // did not answer in time or sent an invalid answer
+)
+
+// peer in the shortlist of a node lookup together with its distance to the key
+type lookupEntry struct {
+	peer     peer
+	distance id
+	state    int
+}
+
+// struct which represents the shortlist of a node lookup
+// entries are sorted by their distance to the key (closest at the beginning)
+type shortlist struct {
+	key     id
+	self    id
+	entries []*lookupEntry
+}
+
+// adds peer to the shortlist if it is neither already contained nor the local peer
+func (shortlist *shortlist) add(p peer) {
+	if p.id == shortlist.self {
+		return
+	}
+	for _, entry := range shortlist.entries {
+		if entry.peer.id == p.id {
+			return
+		}
+	}
+	entry := &lookupEntry{peer: p, distance: distance(shortlist.key, p.id), state: lookupCandidate}
+	i := sort.Search(len(shortlist.entries), func(i int) bool {
+		return bytes.Compare(shortlist.entries[i].distance[:], entry.distance[:]) > 0
+	})
+	shortlist.entries = append(shortlist.entries, nil)
+	copy(shortlist.entries[i+1:], shortlist.entries[i:])
+	shortlist.entries[i] = entry
+}
+
+// returns the number closest entries which have not failed
+func (shortlist *shortlist) closestActive(number int) []*lookupEntry {
+	result := make([]*lookupEntry, 0, number)
+	for _, entry := range shortlist.entries {
+		if len(result) == number {
+			break
+		}
+		if entry.state != lookupFailed {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// returns the closest entry among the k closest active entries that was not yet queried, or nil if there is none
+func (shortlist *shortlist) nextCandidate(k int) *lookupEntry {
+	for _, entry := range shortlist.closestActive(k) {
+		if entry.state == lookupCandidate {
+			return entry
+		}
+	}
+	return nil
+}
+
+// checks if the k closest active entries have all responded
+func (shortlist *shortlist) finished(k int) bool {
+	for _, entry := range shortlist.closestActive(k) {
+		if entry.state != lookupResponded {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the peers of the k closest entries that have responded
+func (shortlist *shortlist) closestResponded(k int) []peer {
+	result := make([]peer, 0, k)
+	for _, entry := range shortlist.entries {
+		if len(result) == k {
+			break
+		}
+		if entry.state == lookupResponded {
+			result = append(result, entry.peer)
+		}
+	}
+	return result
+}
+
+// returns the peer of the closest entry that has responded other than the given one and false if there is none
+func (shortlist *shortlist) closestRespondedExcept(except *lookupEntry) (peer, bool) {
+	for _, entry := range shortlist.entries {
+		if entry != except && entry.state == lookupResponded {
+			return entry.peer, true
+		}
+	}
+	return peer{}, false
+}
+
+// result of a node lookup
+type lookupResult struct {
+	closestPeers []peer // closest peers which responded, ordered by distance
+	value        []byte // only set if a value was searched and found
+	valueFound   bool
+}
+
+// answer (or failure) of a single request sent during a node lookup
+type lookupAnswer struct {
+	entry  *lookupEntry
+	answer *p2pMessage
+	err    error
+}
+
+/*
+nodeLookup finds the number closest peers to the given key (usually k). It keeps a shortlist of peers sorted by their
+distance to the key, with at most a requests in flight at any time. Peers that do not answer within REQUEST_TIMEOUT are
+marked as failed and replaced by the next closest candidate. The lookup terminates as soon as the number closest peers
+that did not fail have all responded. If findValue is set, KDM_FIND_VALUE requests are sent and the lookup terminates as
+soon as a value is found.
+*/
+func (thisNode *Node) nodeLookup(key id, findValue bool, number int) lookupResult {
+	if findValue {
+		// if findValue is set, search in local hashTable
+		value, ok := thisNode.hashTable.read(key)
+		if ok {
+			// value found, halt lookup process
+			log.Debug("VALUE WAS FOUND IN LOCAL HASH TABLE OF ", thisNode.thisPeer.port)
+			return lookupResult{value: value, valueFound: true}
+		}
+	}
+
+	thisNode.touchRoutingTree(key)
+
+	k := number
+	list := shortlist{key: key, self: thisNode.thisPeer.id}
+	for _, p := range thisNode.findNumberOfClosestPeersOnNode(key, k) {
+		list.add(p)
+	}
+
+	answers := make(chan lookupAnswer)
+	done := make(chan struct{})
+	defer close(done)
+	inFlight := 0
+
+	for {
+		// keep up to a requests in flight to the closest peers not yet queried
+		for inFlight < thisNode.conf.A {
+			entry := list.nextCandidate(k)
+			if entry == nil {
+				break
+			}
+			entry.state = lookupQueried
+			inFlight++
+			go thisNode.sendLookupRequest(key, findValue, entry, answers, done)
+		}
+
+		if list.finished(k) || inFlight == 0 {
+			break
+		}
+
+		a := <-answers
+		inFlight--
+		if a.err != nil {
+			log.Debug("No answer from ", a.entry.peer.toString(), ": ", a.err)
+			a.entry.state = lookupFailed
+			continue
+		}
+		a.entry.state = lookupResponded
+		thisNode.updateRoutingTable(answeringPeer(a.answer, a.entry.peer))
+
+		switch a.answer.header.messageType {
+		case KDM_FIND_NODE_ANSWER:
+			// peers whose id does not solve the static puzzle are never contacted
+			for _, p := range a.answer.body.(*kdmFindNodeAnswerBody).answerPeers {
+				if solvesStaticPuzzle(p.id, thisNode.conf.StaticPuzzleDifficulty) {
+					list.add(p)
+				}
+			}
+		case KDM_FOUND_VALUE:
+			body := a.answer.body.(*kdmFoundValueBody)
+			if body.key != key {
+				a.entry.state = lookupFailed
+				continue
+			}
+			// cache found <key, value>-pair at the closest peer on the lookup path which did not have it
+			if cachingPeer, ok := list.closestRespondedExcept(a.entry); ok {
+				thisNode.wg.Add(1)
+				go func(cachingPeer peer, holder peer) {
+					defer thisNode.wg.Done()
+					thisNode.cacheOnPath(key, body.value, cachingPeer, holder)
+				}(cachingPeer, a.entry.peer)
+			}
+			return lookupResult{closestPeers: list.closestResponded(k), value: body.value, valueFound: true}
+		}
+	}
+
+	return lookupResult{closestPeers: list.closestResponded(k)}
+}
+
+// sends a KDM_FIND_NODE or KDM_FIND_VALUE request to the peer of the given entry and reports the outcome on answers
+func (thisNode *Node) sendLookupRequest(key id, findValue bool, entry *lookupEntry, answers chan<- lookupAnswer, done <-chan struct{}) {
+	var m p2pMessage
+	if findValue {
+		m = thisNode.makeP2PMessageOutOfBody(&kdmFindValueBody{id: key}, KDM_FIND_VALUE)
+	} else {
+		m = thisNode.makeP2PMessageOutOfBody(&kdmFindNodeBody{id: key}, KDM_FIND_NODE)
+	}
+	answer, err := thisNode.sendRequest(m, entry.peer)
+	select {
+	case answers <- lookupAnswer{entry: entry, answer: answer, err: err}:
+	case <-done:
+		// lookup already terminated
+	}
+}
+
+// maximum TTL in seconds of a <key, value>-pair cached on the lookup path, used for a peer as close to the key as the
+// peer which returned the value
+const MAX_CACHE_TTL int = 3600
+
+// returns the number of bits of id without its leading zeros
+func (id id) bitLength() int {
+	for i, b := range id {
+		if b != 0 {
+			return (SIZE_OF_ID-i)*8 - bits.LeadingZeros8(b)
+		}
+	}
+	return 0
+}
+
+/*
+cacheTTL returns the TTL in seconds for a <key, value>-pair cached at cachingPeer, which is halved for every bit the
+distance of cachingPeer to key is longer than the one of holder, which returned the value. Every further bit doubles
+the expected number of peers between cachingPeer and the key, so the TTL decreases exponentially with the distance and
+peers far away from the key do not keep the pair for long (see section 2.3 of the Kademlia paper).
+*/
+func cacheTTL(key id, cachingPeer id, holder id) int {
+	shift := distance(key, cachingPeer).bitLength() - distance(key, holder).bitLength()
+	if shift < 0 {
+		shift = 0
+	}
+	if shift >= bits.Len(uint(MAX_CACHE_TTL)) {
+		return 0
+	}
+	return MAX_CACHE_TTL >> shift
+}
+
+// stores the <key, value>-pair found with a lookup at cachingPeer with a TTL depending on its distance to key, marked
+// as cached so cachingPeer does not replicate, hand over or republish it
+func (thisNode *Node) cacheOnPath(key id, value []byte, cachingPeer peer, holder peer) {
+	ttl := cacheTTL(key, cachingPeer.id, holder.id)
+	if ttl == 0 {
+		return
+	}
+	storeMessage := thisNode.makeP2PMessageOutOfBody(&kdmStoreBody{key: key, value: value, ttl: uint16(ttl), flags: STORE_FLAG_CACHED}, KDM_STORE)
+	_, err := thisNode.sendRequest(storeMessage, cachingPeer)
+	if err != nil {
+		log.Debug("Could not cache key at ", cachingPeer.toString(), ": ", err)
+		return
+	}
+	log.Debug("Cached key at ", cachingPeer.toString(), " for ", ttl, "s")
+}
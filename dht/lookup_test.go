@@ -0,0 +1,131 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShortlistAdd(t *testing.T) {
+	self := buildTestIdFromString("0")
+	list := shortlist{key: buildTestIdFromString("1000"), self: self}
+
+	testPeer1 := peer{id: buildTestIdFromString("0001")}
+	testPeer2 := peer{id: buildTestIdFromString("1100")}
+	testPeer3 := peer{id: buildTestIdFromString("1001")}
+
+	list.add(testPeer1)
+	list.add(testPeer2)
+	list.add(testPeer3)
+	list.add(testPeer2)      // duplicates are ignored
+	list.add(peer{id: self}) // the local peer is ignored
+
+	if len(list.entries) != 3 {
+		t.Fatalf("[FAILURE] shortlist should contain 3 entries, has %d", len(list.entries))
+	}
+	if list.entries[0].peer != testPeer3 || list.entries[1].peer != testPeer2 || list.entries[2].peer != testPeer1 {
+		t.Errorf("[FAILURE] shortlist is not sorted by distance to the key")
+	}
+}
+
+func TestShortlistTermination(t *testing.T) {
+	list := shortlist{key: buildTestIdFromString("1000"), self: buildTestIdFromString("0")}
+	testPeer1 := peer{id: buildTestIdFromString("1001")}
+	testPeer2 := peer{id: buildTestIdFromString("1010")}
+	testPeer3 := peer{id: buildTestIdFromString("0001")}
+	list.add(testPeer1)
+	list.add(testPeer2)
+	list.add(testPeer3)
+
+	// only the 2 closest peers are candidates
+	first := list.nextCandidate(2)
+	if first == nil || first.peer != testPeer1 {
+		t.Fatalf("[FAILURE] closest peer has to be queried first")
+	}
+	first.state = lookupQueried
+	second := list.nextCandidate(2)
+	if second == nil || second.peer != testPeer2 {
+		t.Fatalf("[FAILURE] second closest peer has to be queried second")
+	}
+	second.state = lookupQueried
+	if list.nextCandidate(2) != nil {
+		t.Errorf("[FAILURE] peers outside of the k closest must not be queried")
+	}
+
+	// a failed peer is replaced by the next closest one
+	first.state = lookupResponded
+	second.state = lookupFailed
+	if list.finished(2) {
+		t.Errorf("[FAILURE] lookup must not finish before the k closest active peers responded")
+	}
+	third := list.nextCandidate(2)
+	if third == nil || third.peer != testPeer3 {
+		t.Fatalf("[FAILURE] failed peer has to be replaced by the next closest candidate")
+	}
+	third.state = lookupResponded
+	if !list.finished(2) {
+		t.Errorf("[FAILURE] lookup has to finish when the k closest active peers responded")
+	}
+
+	result := list.closestResponded(2)
+	if len(result) != 2 || result[0] != testPeer1 || result[1] != testPeer3 {
+		t.Errorf("[FAILURE] result has to contain the responded peers ordered by distance")
+	}
+}
+
+// Test if the TTL of a cached pair is halved for every bit the caching peer is farther from the key than the holder
+func TestCacheTTL(t *testing.T) {
+	key := buildTestIdFromString("111")
+	holder := buildTestIdFromString("11")
+	if cacheTTL(key, holder, holder) != MAX_CACHE_TTL {
+		t.Errorf("[FAILURE] peer as close as the holder has to cache with MAX_CACHE_TTL")
+	}
+	if cacheTTL(key, buildTestIdFromString("1"), holder) != MAX_CACHE_TTL/2 {
+		t.Errorf("[FAILURE] peer with one more bit of distance has to cache with half of MAX_CACHE_TTL")
+	}
+	if cacheTTL(key, buildTestIdFromString("01"), holder) != MAX_CACHE_TTL/4 {
+		t.Errorf("[FAILURE] peer with two more bits of distance has to cache with a quarter of MAX_CACHE_TTL")
+	}
+	if cacheTTL(buildTestIdFromString("0000000000000001"), buildTestIdFromString("1"), buildTestIdFromString("0000000000000001")) != 0 {
+		t.Errorf("[FAILURE] peer far away from the key must not cache")
+	}
+}
+
+/*
+TestPathCaching looks up a key which is only stored at the holder. The requester only knows a peer on the path to the
+holder, which did not return the value, so the pair has to be cached there with the TTL for its distance.
+*/
+func TestPathCaching(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	holder := startTestNode(t, ctx, "110", 8072)
+	pathPeer := startTestNode(t, ctx, "10", 8073)
+	pathPeer.updateRoutingTable(holder.thisPeer)
+
+	key := buildTestIdFromString("111")
+	holder.hashTable.write(key, []byte("value"), time.Now().Add(time.Hour), time.Now().Add(time.Hour), 0)
+
+	requester := buildTestNode("0", 8071)
+	requester.updateRoutingTable(pathPeer.thisPeer)
+	result := requester.nodeLookup(key, true, requester.conf.K)
+	if !result.valueFound || string(result.value) != "value" {
+		t.Fatal("[FAILURE] value was not found")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if entry, ok := pathPeer.hashTable.storage.read(key); ok {
+			if !entry.cached {
+				t.Errorf("[FAILURE] pair was not marked as cached")
+			}
+			if ttl := time.Until(entry.expiration); ttl > time.Duration(MAX_CACHE_TTL/2)*time.Second || ttl < time.Duration(MAX_CACHE_TTL/2-5)*time.Second {
+				t.Errorf("[FAILURE] pair was cached with a TTL of %v instead of %ds", ttl, MAX_CACHE_TTL/2)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("[FAILURE] pair was not cached at the peer on the lookup path")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
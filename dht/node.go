@@ -0,0 +1,272 @@
+package dht
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Options contains everything needed to construct a Node
+type Options struct {
+	//general
+	HostKeyFile string
+	//dht
+	APIIP   string
+	APIPort uint16
+	P2PIP   string
+	P2PPort uint16
+	MaxTTL  int
+	//bootstrapping: a list of ip:port addresses, a file with one address per line and a host:port DNS name
+	//resolving to several addresses, all of them are optional
+	BootstrapPeers []string
+	SeedFile       string
+	BootstrapDNS   string
+	//file in which the known peers are stored on shutdown and which is read on startup, disabled if empty
+	PeerCacheFile string
+	//file in which the stored <key, value>-pairs are persisted, they are only kept in memory if empty
+	StorageFile string
+	//file in which the routing table is snapshotted periodically and on shutdown and which is read on startup,
+	//disabled if empty
+	RoutingTableFile string
+	//transport of the P2P connections, TRANSPORT_TCP (default) or TRANSPORT_TLS
+	Transport string
+	//leading zero bits required by the static and the dynamic crypto puzzle of the node ids, 0 disables them
+	StaticPuzzleDifficulty  int
+	DynamicPuzzleDifficulty int
+	//kademlia specific
+	K int
+	A int
+	//bounds for the replication degree requested with a dhtPUT, default to 1 and k
+	MinReplication int
+	MaxReplication int
+	//interval in seconds after which a k-Bucket without lookup is refreshed, defaults to REFRESH_INTERVAL
+	RefreshInterval int
+}
+
+func (o *Options) toString() string {
+	str := "Configuration file: "
+	str = str + "   HostKeyFile: " + o.HostKeyFile + "\n"
+	str = str + "   apiIP: " + o.APIIP + "\n"
+	str = str + "   apiPort: " + strconv.Itoa(int(o.APIPort)) + "\n"
+	str = str + "   p2pIP: " + o.P2PIP + "\n"
+	str = str + "   p2pPort: " + strconv.Itoa(int(o.P2PPort)) + "\n"
+	str = str + "   maxTTL: " + strconv.Itoa(o.MaxTTL) + "\n"
+	str = str + "   minReplication: " + strconv.Itoa(o.MinReplication) + "\n"
+	str = str + "   maxReplication: " + strconv.Itoa(o.MaxReplication) + "\n"
+	str = str + "   refreshInterval: " + strconv.Itoa(o.RefreshInterval) + "\n"
+	str = str + "   bootstrapPeers: " + strings.Join(o.BootstrapPeers, ", ") + "\n"
+	str = str + "   seedFile: " + o.SeedFile + "\n"
+	str = str + "   bootstrapDNS: " + o.BootstrapDNS + "\n"
+	str = str + "   peerCache: " + o.PeerCacheFile + "\n"
+	str = str + "   storageFile: " + o.StorageFile + "\n"
+	str = str + "   routingTableFile: " + o.RoutingTableFile + "\n"
+	str = str + "   transport: " + o.Transport + "\n"
+	str = str + "   staticPuzzleDifficulty: " + strconv.Itoa(o.StaticPuzzleDifficulty) + "\n"
+	str = str + "   dynamicPuzzleDifficulty: " + strconv.Itoa(o.DynamicPuzzleDifficulty) + "\n"
+	return str
+}
+
+// Node represents one local peer in the network
+// it owns its routing table, its data storage and its listeners, so several nodes can run in one process
+type Node struct {
+	conf        Options
+	thisPeer    peer
+	routingTree routingTree
+	hashTable   hashTable
+
+	// host key with which all P2P messages are signed and its public key in PKIX, DER encoding
+	hostKey   crypto.Signer
+	publicKey []byte
+	// certificate of the host key, only set if the P2P connections use TRANSPORT_TLS
+	tlsCertificate *tls.Certificate
+	// solution of the dynamic puzzle, sent with every P2P message
+	puzzleSolution id
+
+	// guards routingTree, which is updated and read by every connection handler and lookup
+	routingTreeLock sync.RWMutex
+
+	pendingRequests pendingRequests
+	replayCache     replayCache
+	verifications   pendingVerifications
+	// newcomers of the routing table which still receive the pairs they are responsible for
+	replicationQueue replicationQueue
+	// pairs taken for republishing which wait for or are handled by the republishing workers
+	republishQueue republishQueue
+
+	apiListener net.Listener
+	p2pListener net.Listener
+	// context of the running node, canceled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewNode creates a node out of the given options
+// the ID of the node is derived from the configured host key, which is also used to sign all P2P messages
+func NewNode(opts Options) (*Node, error) {
+	if opts.K <= 0 || opts.A <= 0 {
+		return nil, errors.New("k and a have to be positive")
+	}
+	if opts.MinReplication == 0 {
+		opts.MinReplication = 1
+	}
+	if opts.MaxReplication == 0 {
+		opts.MaxReplication = opts.K
+	}
+	if opts.MinReplication < 0 || opts.MinReplication > opts.MaxReplication || opts.MaxReplication > 255 {
+		return nil, errors.New("minReplication and maxReplication have to satisfy 1 <= minReplication <= maxReplication <= 255")
+	}
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = REFRESH_INTERVAL
+	}
+	if opts.RefreshInterval < 0 {
+		return nil, errors.New("refreshInterval has to be positive")
+	}
+	for _, address := range opts.BootstrapPeers {
+		if _, err := extractPeerAddressFromString(address); err != nil {
+			return nil, errors.New("Wrong bootstrap peer: " + err.Error())
+		}
+	}
+	hostKey, publicKey, err := readHostKey(opts.HostKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	thisNode := &Node{conf: opts, hostKey: hostKey, publicKey: publicKey}
+	thisNode.thisPeer = peer{ip: opts.P2PIP, port: opts.P2PPort, id: idOfPublicKey(publicKey)}
+	if err = thisNode.setupPuzzles(); err != nil {
+		return nil, err
+	}
+	if err = thisNode.setupTransport(); err != nil {
+		return nil, err
+	}
+	thisNode.routingTree = routingTree{
+		left:    nil,
+		right:   nil,
+		parent:  nil,
+		prefix:  "",
+		k:       opts.K,
+		kBucket: kBucket{},
+
+		lastLookup: time.Now(),
+	}
+	if opts.StorageFile != "" {
+		thisNode.hashTable.storage, err = openDiskStorage(opts.StorageFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		thisNode.hashTable.storage = newMemoryStorage()
+	}
+	log.Info("[SUCCESS] Configured this peer: ", thisNode.thisPeer.toString())
+	return thisNode, nil
+}
+
+// Start opens the API and P2P listeners, joins the network and starts the timers
+// API requests are only served after the join procedure is finished
+// the node runs until ctx is canceled or Close is called, also if ErrNoBootstrapPeer is returned as no network could be
+// joined; the node is the first peer of a new network then
+func (thisNode *Node) Start(ctx context.Context) error {
+	var err error
+	thisNode.p2pListener, err = thisNode.listenP2P(thisNode.conf.P2PIP + ":" + strconv.Itoa(int(thisNode.conf.P2PPort)))
+	if err != nil {
+		return errors.New("Error while listening for connection at " + thisNode.conf.P2PIP + ": " + strconv.Itoa(int(thisNode.conf.P2PPort)) + " - " + err.Error())
+	}
+	thisNode.apiListener, err = net.Listen("tcp", thisNode.conf.APIIP+":"+strconv.Itoa(int(thisNode.conf.APIPort)))
+	if err != nil {
+		thisNode.p2pListener.Close()
+		return errors.New("Error while listening for connection at " + thisNode.conf.APIIP + ": " + strconv.Itoa(int(thisNode.conf.APIPort)) + " - " + err.Error())
+	}
+
+	thisNode.ctx, thisNode.cancel = context.WithCancel(ctx)
+	ctx = thisNode.ctx
+	thisNode.wg.Add(3)
+	go thisNode.startP2PMessageDispatcher(ctx)
+
+	// join the network before API requests are served
+	joinErr := thisNode.initializeP2PCommunication()
+	if joinErr == nil {
+		log.Info("[SUCCESS] MAIN: Joined the network")
+	}
+
+	go thisNode.startAPIMessageDispatcher(ctx)
+	go thisNode.startTimers(ctx)
+	return joinErr
+}
+
+// Close stops the node: no further connections are accepted and Close waits until the connections and lookups in
+// progress and the timers have finished. Then the stored <key, value>-pairs are handed over to the closest remaining
+// peers with their remaining TTL, the known peers are written to the peer cache file and the routing table snapshot,
+// if they are configured, and the storage is closed
+func (thisNode *Node) Close() error {
+	if thisNode.cancel == nil {
+		return errors.New("node was not started")
+	}
+	thisNode.cancel()
+	thisNode.wg.Wait()
+	thisNode.hashTable.handOverKeys(thisNode)
+	metrics := thisNode.replayCache.snapshotMetrics()
+	log.Info("Dropped ", metrics.Replayed, " replayed and ", metrics.Stale, " stale messages, evicted ", metrics.EvictedSenders, " senders from the replay cache")
+	var err error
+	if thisNode.conf.PeerCacheFile != "" {
+		err = thisNode.writePeerCache()
+	}
+	if thisNode.conf.RoutingTableFile != "" {
+		if snapshotErr := thisNode.writeRoutingTableSnapshot(); err == nil {
+			err = snapshotErr
+		}
+	}
+	if closeErr := thisNode.hashTable.storage.close(); err == nil && closeErr != nil {
+		err = errors.New("Could not close storage: " + closeErr.Error())
+	}
+	return err
+}
+
+// reports if the node was canceled; background work which sends messages or changes the routing table is not started
+// anymore then, as Close might already be waiting for the work in progress or have returned
+func (thisNode *Node) closing() bool {
+	return thisNode.ctx != nil && thisNode.ctx.Err() != nil
+}
+
+// Put stores the <key, value>-pair in the network and additionally caches it locally
+// replication is the requested number of replicas, 0 uses the default of k
+// returns the number of replicas that confirmed the storage or an error if there was none
+func (thisNode *Node) Put(key [SIZE_OF_ID]byte, value []byte, ttl uint16, replication uint8) (int, error) {
+	answer := thisNode.put(key, value, ttl, replication)
+	if answer.replicas == 0 {
+		return 0, errors.New("no peer confirmed the storage of the key")
+	}
+	return int(answer.replicas), nil
+}
+
+func (thisNode *Node) put(key id, value []byte, ttl uint16, replication uint8) putAnswer {
+	// store on network
+	confirmed, targets := thisNode.store(key, value, ttl, replication)
+	thisNode.hashTable.write(key, value, time.Now().Add(time.Duration(ttl)*time.Second), time.Now().Add(time.Duration(REPUBLISH_TIME)*time.Second), replication)
+	return putAnswer{key: key, replicas: clampToUint8(confirmed), targets: clampToUint8(targets)}
+}
+
+// returns number as uint8, numbers above 255 are reported as 255
+func clampToUint8(number int) uint8 {
+	if number > math.MaxUint8 {
+		return math.MaxUint8
+	}
+	return uint8(number)
+}
+
+// Get looks for the value of the given key, first locally and then in the network
+// returns the value or nil and a boolean if the value was found
+func (thisNode *Node) Get(key [SIZE_OF_ID]byte) ([]byte, bool) {
+	// nodeLookup looks into the local hashTable first and only contacts other peers if the value is not stored locally
+	result := thisNode.nodeLookup(key, true, thisNode.conf.K)
+	return result.value, result.valueFound
+}
@@ -0,0 +1,130 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"os"
+	"strconv"
+)
+
+/*
+Crypto puzzles as in S/Kademlia make it expensive to create many node IDs, e.g. to surround a key with Sybil peers:
+  - static puzzle: the SHA-256 hash of the node ID has to start with staticPuzzleDifficulty zero bits. As the node ID is
+    the hash of the public key, about 2^staticPuzzleDifficulty host keys have to be generated to find one that solves it
+    (see GenerateHostKey).
+  - dynamic puzzle: the node has to find a value X such that the SHA-256 hash of (node ID XOR X) starts with
+    dynamicPuzzleDifficulty zero bits. X is searched on every start and sent with every P2P message after the signature.
+Received messages of peers which do not solve both puzzles with the difficulties configured locally are dropped.
+Additionally every routing table insertion checks the static puzzle, and peers returned in a KDM_FIND_NODE_ANSWER are
+only contacted during a lookup if they solve it. Both difficulties default to 0, which disables the puzzles; all nodes
+of a network have to use the same ones.
+*/
+
+// upper bound for both difficulties, higher ones could not be solved in practice
+const MAX_PUZZLE_DIFFICULTY = 40
+
+// errors returned if a peer does not solve the puzzles
+var (
+	errStaticPuzzle  = errors.New("id of the sender does not solve the static puzzle")
+	errDynamicPuzzle = errors.New("sender did not solve the dynamic puzzle")
+)
+
+// returns the number of leading zero bits of id
+func (id id) leadingZeros() int {
+	return SIZE_OF_ID*8 - id.bitLength()
+}
+
+// checks if nodeID solves the static puzzle of the given difficulty
+func solvesStaticPuzzle(nodeID id, difficulty int) bool {
+	hash := id(sha256.Sum256(nodeID[:]))
+	return hash.leadingZeros() >= difficulty
+}
+
+// checks if solution solves the dynamic puzzle of nodeID with the given difficulty
+func solvesDynamicPuzzle(nodeID id, solution id, difficulty int) bool {
+	xored := distance(nodeID, solution)
+	hash := id(sha256.Sum256(xored[:]))
+	return hash.leadingZeros() >= difficulty
+}
+
+// searches a solution of the dynamic puzzle of nodeID, starting at a random value and counting up
+func solveDynamicPuzzle(nodeID id, difficulty int) id {
+	var solution id
+	if difficulty == 0 {
+		return solution
+	}
+	if _, err := rand.Read(solution[:]); err != nil {
+		panic(err.Error())
+	}
+	for !solvesDynamicPuzzle(nodeID, solution, difficulty) {
+		counter := solution[SIZE_OF_ID-8:]
+		binary.BigEndian.PutUint64(counter, binary.BigEndian.Uint64(counter)+1)
+	}
+	return solution
+}
+
+// checks that the sender of message m solves the puzzles with the difficulties of the local node
+func (thisNode *Node) verifyPuzzles(m *p2pMessage) error {
+	if !solvesStaticPuzzle(m.header.senderPeer.id, thisNode.conf.StaticPuzzleDifficulty) {
+		return errStaticPuzzle
+	}
+	if !solvesDynamicPuzzle(m.header.senderPeer.id, m.puzzleSolution, thisNode.conf.DynamicPuzzleDifficulty) {
+		return errDynamicPuzzle
+	}
+	return nil
+}
+
+// checks that the difficulties are in range, the host key solves the static puzzle and solves the dynamic puzzle
+func (thisNode *Node) setupPuzzles() error {
+	for _, difficulty := range []int{thisNode.conf.StaticPuzzleDifficulty, thisNode.conf.DynamicPuzzleDifficulty} {
+		if difficulty < 0 || difficulty > MAX_PUZZLE_DIFFICULTY {
+			return errors.New("puzzle difficulties have to be between 0 and " + strconv.Itoa(MAX_PUZZLE_DIFFICULTY))
+		}
+	}
+	if !solvesStaticPuzzle(thisNode.thisPeer.id, thisNode.conf.StaticPuzzleDifficulty) {
+		return errors.New("host key does not solve the static puzzle of difficulty " + strconv.Itoa(thisNode.conf.StaticPuzzleDifficulty) + ", generate a new one with -genkey")
+	}
+	thisNode.puzzleSolution = solveDynamicPuzzle(thisNode.thisPeer.id, thisNode.conf.DynamicPuzzleDifficulty)
+	return nil
+}
+
+/*
+GenerateHostKey generates Ed25519 host keys until the ID of one solves the static puzzle of the given difficulty and
+writes it to hostKeyFile in PKCS #8 encoding. An existing file is not overwritten.
+*/
+func GenerateHostKey(hostKeyFile string, staticDifficulty int) error {
+	if staticDifficulty < 0 || staticDifficulty > MAX_PUZZLE_DIFFICULTY {
+		return errors.New("puzzle difficulties have to be between 0 and " + strconv.Itoa(MAX_PUZZLE_DIFFICULTY))
+	}
+	for {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		publicKeyDer, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return err
+		}
+		if !solvesStaticPuzzle(idOfPublicKey(publicKeyDer), staticDifficulty) {
+			continue
+		}
+		privateKeyDer, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(hostKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return errors.New("Could not create host key file: " + err.Error())
+		}
+		err = pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyDer})
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
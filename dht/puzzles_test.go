@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// Test if solutions of the dynamic puzzle are found and verified
+func TestDynamicPuzzle(t *testing.T) {
+	nodeID := buildTestIdFromString("0101")
+	solution := solveDynamicPuzzle(nodeID, 10)
+	if !solvesDynamicPuzzle(nodeID, solution, 10) {
+		t.Errorf("[FAILURE] found solution does not solve the puzzle")
+	}
+	if solvesDynamicPuzzle(buildTestIdFromString("1010"), solution, 10) && solvesDynamicPuzzle(buildTestIdFromString("0110"), solution, 10) {
+		t.Errorf("[FAILURE] solution solves the puzzles of other ids")
+	}
+	if !solvesDynamicPuzzle(nodeID, id{}, 0) {
+		t.Errorf("[FAILURE] puzzle of difficulty 0 has to be solved by any value")
+	}
+}
+
+// Test if generated host keys solve the static puzzle and nodes refuse host keys that do not
+func TestStaticPuzzle(t *testing.T) {
+	hostKeyFile := filepath.Join(t.TempDir(), "hostkey.pem")
+	if err := GenerateHostKey(hostKeyFile, 8); err != nil {
+		t.Fatal("[FAILURE] could not generate host key: ", err)
+	}
+	if GenerateHostKey(hostKeyFile, 8) == nil {
+		t.Errorf("[FAILURE] existing host key was overwritten")
+	}
+	opts := Options{HostKeyFile: hostKeyFile, P2PIP: "127.0.0.1", P2PPort: 8107, K: 5, A: 3, StaticPuzzleDifficulty: 8, DynamicPuzzleDifficulty: 8}
+	testNode, err := NewNode(opts)
+	if err != nil {
+		t.Fatal("[FAILURE] node with a host key solving the puzzle was not created: ", err)
+	}
+	if !solvesStaticPuzzle(testNode.thisPeer.id, 8) || !solvesDynamicPuzzle(testNode.thisPeer.id, testNode.puzzleSolution, 8) {
+		t.Errorf("[FAILURE] node does not solve the puzzles")
+	}
+
+	// the chance that the id of the key solves a puzzle of difficulty 30 is negligible
+	opts.StaticPuzzleDifficulty = 30
+	if _, err = NewNode(opts); err == nil {
+		t.Errorf("[FAILURE] node with a host key not solving the puzzle was created")
+	}
+	opts.StaticPuzzleDifficulty = MAX_PUZZLE_DIFFICULTY + 1
+	if _, err = NewNode(opts); err == nil {
+		t.Errorf("[FAILURE] node with a too high difficulty was created")
+	}
+}
+
+// Test if messages of senders which did not solve the dynamic puzzle are dropped
+func TestDropUnsolvedPuzzle(t *testing.T) {
+	receiver := buildTestNode("1", 8108)
+	receiver.conf.DynamicPuzzleDifficulty = 8
+	sender := buildTestNode("01", 8109)
+
+	for _, solved := range []bool{false, true} {
+		if solved {
+			sender.puzzleSolution = solveDynamicPuzzle(sender.thisPeer.id, 8)
+		}
+		client, server := net.Pipe()
+		go receiver.handleP2PConnection(server)
+		if err := writeMessage(client, sender.makeP2PMessageOutOfBody(nil, KDM_PING)); err != nil {
+			t.Fatal("[FAILURE] could not write message: ", err)
+		}
+		answer, err := readMessage(client)
+		client.Close()
+		if !solved && err == nil {
+			t.Errorf("[FAILURE] message without solution of the dynamic puzzle was answered")
+		}
+		if solved && (err != nil || answer.puzzleSolution != receiver.puzzleSolution) {
+			t.Errorf("[FAILURE] message with solution of the dynamic puzzle was not answered: %v", err)
+		}
+	}
+}
+
+// builds test nodes until one whose id solves the static puzzle of the given difficulty, or does not, is found
+func buildTestPuzzleNode(prefix string, port uint16, difficulty int, solving bool) *Node {
+	for {
+		testNode := buildTestNode(prefix, port)
+		if solvesStaticPuzzle(testNode.thisPeer.id, difficulty) == solving {
+			return testNode
+		}
+	}
+}
+
+// Test if peers whose id does not solve the static puzzle are never inserted into the routing table or contacted
+func TestStaticPuzzleInsertion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requester := buildTestPuzzleNode("0", 8114, 4, true)
+	requester.conf.StaticPuzzleDifficulty = 4
+	// the other peers do not require the puzzle
+	solving := serveTestNode(t, ctx, buildTestPuzzleNode("1", 8115, 4, true))
+	notSolving := serveTestNode(t, ctx, buildTestPuzzleNode("11", 8116, 4, false))
+
+	requester.updateRoutingTable(notSolving.thisPeer)
+	requester.updateRoutingTable(solving.thisPeer)
+	if known := requester.knownPeers(); len(known) != 1 || known[0].id != solving.thisPeer.id {
+		t.Errorf("[FAILURE] peer not solving the static puzzle was inserted into the routing table")
+	}
+
+	// the solving peer returns the other one in its KDM_FIND_NODE_ANSWER
+	solving.updateRoutingTable(notSolving.thisPeer)
+	requester.nodeLookup(buildTestIdFromString("111"), false, 5)
+	notSolving.replayCache.Lock()
+	contacted := len(notSolving.replayCache.senders) != 0
+	notSolving.replayCache.Unlock()
+	if contacted {
+		t.Errorf("[FAILURE] peer not solving the static puzzle was contacted during a lookup")
+	}
+	if len(requester.knownPeers()) != 1 {
+		t.Errorf("[FAILURE] peer not solving the static puzzle was inserted during a lookup")
+	}
+}
@@ -0,0 +1,195 @@
+package dht
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+/*
+Every p2pMessage carries the time at which it was built (milliseconds since the epoch) and a random nonce, both covered
+by the signature. A receiver accepts a message only if its timestamp lies within REPLAY_WINDOW of the local time and its
+nonce was not seen from the same sender before. The seen nonces are kept per sender as long as their message could still
+pass the timestamp check, so a captured message is either recognized as a duplicate or as stale.
+
+The cache is bounded: if a sender has MAX_NONCES_PER_SENDER nonces in the window, its oldest one is evicted and every
+message of the sender which is not newer than the evicted one is treated as stale. If MAX_REPLAY_SENDERS senders are
+tracked, the least-recently active sender is evicted, and the global floor is raised to its newest timestamp: messages
+of senders which are not tracked have to be newer than the global floor, as they could have been evicted before.
+The floor is never raised beyond the local time at which the evicted sender was last active, as timestamps up to
+REPLAY_WINDOW in the future are accepted, and it does not apply to the new sender whose arrival caused the eviction.
+Filling the cache with new identities therefore only rejects messages of new senders which are older than the last
+activity of the evicted senders, but never locks new senders out.
+*/
+
+// messages whose timestamp differs more from the local time are stale
+const REPLAY_WINDOW = 60 * time.Second
+
+// bounds of the replay cache, see above
+const MAX_NONCES_PER_SENDER = 128
+const MAX_REPLAY_SENDERS = 4096
+
+// errors returned by replayCache.check
+var (
+	errReplayedMessage = errors.New("nonce was already received from the sender")
+	errStaleMessage    = errors.New("timestamp of the message is outside of the replay window")
+)
+
+// a nonce which was received from a sender and the timestamp of its message
+type seenNonce struct {
+	nonce     string
+	timestamp int64
+}
+
+// the nonces received from one sender in the replay window, in the order in which they were received
+type seenNonces struct {
+	sender id
+	order  []seenNonce
+	set    map[string]bool
+	// messages with a timestamp up to the one of the latest evicted nonce are rejected
+	floor int64
+	// newest timestamp received from the sender and local time at which its latest message was received
+	newest     int64
+	lastActive int64
+	// position of the sender in replayCache.activity
+	element *list.Element
+}
+
+// ReplayMetrics counts the messages which were dropped by the replay protection
+type ReplayMetrics struct {
+	Replayed       uint64 // nonce was already received from the sender
+	Stale          uint64 // timestamp outside of the replay window or not newer than an evicted nonce
+	EvictedSenders uint64 // least-recently active senders which were evicted as the cache was full
+}
+
+// struct which remembers the nonces of the received messages per sender, the zero value is ready to use
+type replayCache struct {
+	senders map[id]*seenNonces
+	// the tracked senders, the least-recently active one first
+	activity list.List
+	// messages of senders which are not tracked have to be newer
+	floor   int64
+	metrics ReplayMetrics
+	sync.Mutex
+}
+
+// returns the timestamp of a message built at time t
+func timestampOf(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// checks if message m, received at time now, is neither stale nor a replay and remembers its nonce
+func (cache *replayCache) check(m *p2pMessage, now time.Time) error {
+	cache.Lock()
+	defer cache.Unlock()
+	window := int64(REPLAY_WINDOW / time.Millisecond)
+	current := timestampOf(now)
+	if m.header.timestamp < current-window || m.header.timestamp > current+window {
+		cache.metrics.Stale++
+		return errStaleMessage
+	}
+
+	if cache.senders == nil {
+		cache.senders = make(map[id]*seenNonces)
+	}
+	sender := m.header.senderPeer.id
+	seen, ok := cache.senders[sender]
+	if !ok {
+		cache.removeIdle(current - window)
+		// the floor raised by the eviction below only protects the evicted sender, which is not this one
+		seen = &seenNonces{sender: sender, set: make(map[string]bool), floor: current - window}
+		if cache.floor > seen.floor {
+			seen.floor = cache.floor
+		}
+		if len(cache.senders) >= MAX_REPLAY_SENDERS {
+			cache.evictLeastRecentlyActive()
+		}
+		seen.element = cache.activity.PushBack(seen)
+		cache.senders[sender] = seen
+	}
+	cache.activity.MoveToBack(seen.element)
+	seen.lastActive = current
+	seen.removeExpired(current - window)
+
+	nonce := string(m.header.nonce)
+	if m.header.timestamp <= seen.floor {
+		cache.metrics.Stale++
+		return errStaleMessage
+	}
+	if seen.set[nonce] {
+		cache.metrics.Replayed++
+		return errReplayedMessage
+	}
+	if len(seen.order) >= MAX_NONCES_PER_SENDER {
+		evicted := seen.order[0]
+		seen.order = seen.order[1:]
+		delete(seen.set, evicted.nonce)
+		if evicted.timestamp > seen.floor {
+			seen.floor = evicted.timestamp
+		}
+	}
+	seen.order = append(seen.order, seenNonce{nonce: nonce, timestamp: m.header.timestamp})
+	seen.set[nonce] = true
+	if m.header.timestamp > seen.newest {
+		seen.newest = m.header.timestamp
+	}
+	return nil
+}
+
+// removes the least-recently active senders as long as none of their nonces is in the window; the lock has to be held
+func (cache *replayCache) removeIdle(oldest int64) {
+	for front := cache.activity.Front(); front != nil; front = cache.activity.Front() {
+		seen := front.Value.(*seenNonces)
+		if seen.newest >= oldest || seen.floor >= oldest {
+			return
+		}
+		cache.remove(seen)
+	}
+}
+
+// evicts the least-recently active sender and raises the global floor to its newest timestamp, but at most to the local
+// time at which its latest message was received; the lock has to be held
+func (cache *replayCache) evictLeastRecentlyActive() {
+	seen := cache.activity.Front().Value.(*seenNonces)
+	cache.remove(seen)
+	cache.metrics.EvictedSenders++
+	floor := seen.newest
+	if seen.floor > floor {
+		floor = seen.floor
+	}
+	if floor > seen.lastActive {
+		floor = seen.lastActive
+	}
+	if floor > cache.floor {
+		cache.floor = floor
+	}
+}
+
+// removes a sender from the cache; the lock has to be held
+func (cache *replayCache) remove(seen *seenNonces) {
+	cache.activity.Remove(seen.element)
+	delete(cache.senders, seen.sender)
+}
+
+// removes the nonces received first as long as their timestamp lies before oldest
+func (seen *seenNonces) removeExpired(oldest int64) {
+	expired := 0
+	for expired < len(seen.order) && seen.order[expired].timestamp < oldest {
+		delete(seen.set, seen.order[expired].nonce)
+		expired++
+	}
+	seen.order = seen.order[expired:]
+}
+
+// returns the number of messages dropped by the replay protection so far
+func (cache *replayCache) snapshotMetrics() ReplayMetrics {
+	cache.Lock()
+	defer cache.Unlock()
+	return cache.metrics
+}
+
+// ReplayMetrics returns the number of messages dropped as replays or as stale and of the evicted senders
+func (thisNode *Node) ReplayMetrics() ReplayMetrics {
+	return thisNode.replayCache.snapshotMetrics()
+}
@@ -0,0 +1,179 @@
+package dht
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test if duplicates and messages outside of the replay window are rejected and counted
+func TestReplayCache(t *testing.T) {
+	var cache replayCache
+	sender := buildTestNode("0", 8096)
+	now := time.Now()
+
+	m := sender.makeP2PMessageOutOfBody(nil, KDM_PING)
+	if err := cache.check(&m, now); err != nil {
+		t.Errorf("[FAILURE] new message was rejected: %v", err)
+	}
+	if cache.check(&m, now) != errReplayedMessage {
+		t.Errorf("[FAILURE] replayed message was accepted")
+	}
+	// the message is still rejected when the nonce has left the cache
+	if cache.check(&m, now.Add(REPLAY_WINDOW+time.Second)) != errStaleMessage {
+		t.Errorf("[FAILURE] message older than the replay window was accepted")
+	}
+	if cache.check(&m, now.Add(-REPLAY_WINDOW-time.Second)) != errStaleMessage {
+		t.Errorf("[FAILURE] message from the future was accepted")
+	}
+
+	// the nonce of an answer is the one of the request, it is only a replay if it is sent by the same peer
+	receiver := buildTestNode("1", 8097)
+	answer := receiver.makeP2PAnswerOutOfBody(nil, KDM_PONG, &m)
+	if err := cache.check(&answer, now); err != nil {
+		t.Errorf("[FAILURE] answer with the nonce of the request was rejected: %v", err)
+	}
+
+	metrics := cache.snapshotMetrics()
+	if metrics.Replayed != 1 || metrics.Stale != 2 || metrics.EvictedSenders != 0 {
+		t.Errorf("[FAILURE] wrong metrics: %+v", metrics)
+	}
+}
+
+// Test if the number of nonces per sender is bounded and evicted nonces can not be replayed
+func TestReplayCacheEviction(t *testing.T) {
+	var cache replayCache
+	sender := buildTestNode("0", 8096)
+	now := time.Now()
+
+	first := sender.makeP2PMessageOutOfBody(nil, KDM_PING)
+	first.header.timestamp = timestampOf(now) - 1
+	if err := cache.check(&first, now); err != nil {
+		t.Fatal("[FAILURE] new message was rejected: ", err)
+	}
+	for i := 0; i < MAX_NONCES_PER_SENDER; i++ {
+		m := sender.makeP2PMessageOutOfBody(nil, KDM_PING)
+		if err := cache.check(&m, now); err != nil {
+			t.Fatal("[FAILURE] new message was rejected: ", err)
+		}
+	}
+	if len(cache.senders[sender.thisPeer.id].order) != MAX_NONCES_PER_SENDER {
+		t.Errorf("[FAILURE] cache holds %d nonces of the sender", len(cache.senders[sender.thisPeer.id].order))
+	}
+	if cache.check(&first, now) != errStaleMessage {
+		t.Errorf("[FAILURE] evicted message was accepted again")
+	}
+	m := sender.makeP2PMessageOutOfBody(nil, KDM_PING)
+	if err := cache.check(&m, now); err != nil {
+		t.Errorf("[FAILURE] new message was rejected after an eviction: %v", err)
+	}
+
+	// senders without nonces in the window are removed
+	later := now.Add(REPLAY_WINDOW + time.Minute)
+	cache.removeIdle(timestampOf(later) - int64(REPLAY_WINDOW/time.Millisecond))
+	if len(cache.senders) != 0 {
+		t.Errorf("[FAILURE] %d senders without nonces in the window are still tracked", len(cache.senders))
+	}
+}
+
+// builds an unsigned message of the given sender with a random nonce, the replay cache does not check signatures
+func buildTestReplayMessage(sender id, timestamp int64) *p2pMessage {
+	m := &p2pMessage{header: p2pHeader{senderPeer: peer{id: sender}, nonce: make([]byte, SIZE_OF_NONCE), timestamp: timestamp}}
+	if _, err := rand.Read(m.header.nonce); err != nil {
+		panic(err.Error())
+	}
+	return m
+}
+
+// Test if a full cache evicts the least-recently active sender instead of rejecting new senders
+func TestReplayCacheFull(t *testing.T) {
+	var cache replayCache
+	now := time.Now()
+	current := timestampOf(now)
+
+	senderID := func(i int) id {
+		var result id
+		binary.BigEndian.PutUint32(result[:], uint32(i)+1)
+		return result
+	}
+	first := buildTestReplayMessage(senderID(0), current-10)
+	if err := cache.check(first, now); err != nil {
+		t.Fatal("[FAILURE] new message was rejected: ", err)
+	}
+	for i := 1; i < MAX_REPLAY_SENDERS; i++ {
+		if err := cache.check(buildTestReplayMessage(senderID(i), current-5), now); err != nil {
+			t.Fatal("[FAILURE] new message was rejected: ", err)
+		}
+	}
+
+	newcomer := buildTestReplayMessage(senderID(MAX_REPLAY_SENDERS), current)
+	if err := cache.check(newcomer, now); err != nil {
+		t.Errorf("[FAILURE] message of a new sender was rejected by the full cache: %v", err)
+	}
+	if len(cache.senders) != MAX_REPLAY_SENDERS || cache.senders[senderID(0)] != nil {
+		t.Errorf("[FAILURE] least-recently active sender was not evicted")
+	}
+	// the evicted sender is not tracked anymore, its nonce is rejected by the global floor
+	if cache.check(first, now) != errStaleMessage {
+		t.Errorf("[FAILURE] message of an evicted sender was accepted again")
+	}
+	if err := cache.check(buildTestReplayMessage(senderID(0), current), now); err != nil {
+		t.Errorf("[FAILURE] new message of an evicted sender was rejected: %v", err)
+	}
+	if metrics := cache.snapshotMetrics(); metrics.EvictedSenders != 2 || metrics.Stale != 1 {
+		t.Errorf("[FAILURE] wrong metrics: %+v", metrics)
+	}
+}
+
+// Test if senders with timestamps in the future can not raise the global floor beyond the local time
+func TestReplayCacheFutureFlood(t *testing.T) {
+	var cache replayCache
+	now := time.Now()
+	current := timestampOf(now)
+	future := current + int64(REPLAY_WINDOW/time.Millisecond) - 1000
+
+	for i := 0; i <= MAX_REPLAY_SENDERS; i++ {
+		var sender id
+		binary.BigEndian.PutUint32(sender[:], uint32(i)+1)
+		if err := cache.check(buildTestReplayMessage(sender, future), now); err != nil {
+			t.Fatal("[FAILURE] new message was rejected: ", err)
+		}
+	}
+	if cache.floor > current {
+		t.Errorf("[FAILURE] global floor was raised %dms beyond the local time", cache.floor-current)
+	}
+	// a new sender whose message was built after the flood is accepted
+	later := now.Add(time.Millisecond)
+	newcomer := buildTestReplayMessage(buildTestIdFromString("1"), timestampOf(later))
+	if err := cache.check(newcomer, later); err != nil {
+		t.Errorf("[FAILURE] message of a new sender was rejected after a flood of future timestamps: %v", err)
+	}
+}
+
+// Test if a request which is sent twice is only processed once
+func TestDropReplayedMessage(t *testing.T) {
+	receiver := buildTestNode("1", 8098)
+	sender := buildTestNode("01", 8099)
+	store := sender.makeP2PMessageOutOfBody(&kdmStoreBody{key: buildTestIdFromString("11"), ttl: 20, value: []byte("value")}, KDM_STORE)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		client, server := net.Pipe()
+		go receiver.handleP2PConnection(server)
+		if err := writeMessage(client, store); err != nil {
+			t.Fatal("[FAILURE] could not write message: ", err)
+		}
+		_, err := readMessage(client)
+		client.Close()
+		if attempt == 0 && err != nil {
+			t.Fatal("[FAILURE] KDM_STORE was not acknowledged: ", err)
+		}
+		if attempt == 1 && err == nil {
+			t.Errorf("[FAILURE] replayed KDM_STORE was acknowledged")
+		}
+	}
+	if receiver.ReplayMetrics().Replayed != 1 {
+		t.Errorf("[FAILURE] replayed message was not counted")
+	}
+}
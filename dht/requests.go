@@ -0,0 +1,179 @@
+package dht
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// time a requesting peer waits for the answer to a KDM_PING, KDM_STORE, KDM_FIND_NODE or KDM_FIND_VALUE request
+const REQUEST_TIMEOUT = 3 * time.Second
+
+// errors returned by sendRequest
+var (
+	errRequestTimeout    = errors.New("no answer received before timeout")
+	errUnsolicitedAnswer = errors.New("answer does not belong to an open request")
+)
+
+// a request which was sent to another peer and is still waiting for its answer
+type pendingRequest struct {
+	messageType uint16
+	// id of the peer the request was sent to, zero if it is not known yet (bootstrap peers)
+	receiver id
+	// key or id the request is about, zero for KDM_PING
+	key    id
+	answer chan *p2pMessage // buffered, receives at most one answer
+}
+
+// struct which correlates answers with open requests
+// the 20 byte nonce of the request header is used as request ID and is copied into the header of the answer
+type pendingRequests struct {
+	requests map[string]pendingRequest
+	sync.Mutex
+}
+
+// registers a new open request to receiverPeer and returns the future on which its answer will be delivered
+func (pendingRequests *pendingRequests) add(request *p2pMessage, receiverPeer peer) chan *p2pMessage {
+	pendingRequests.Lock()
+	defer pendingRequests.Unlock()
+	if pendingRequests.requests == nil {
+		pendingRequests.requests = make(map[string]pendingRequest)
+	}
+	future := make(chan *p2pMessage, 1)
+	pendingRequests.requests[string(request.header.nonce)] = pendingRequest{
+		messageType: request.header.messageType,
+		receiver:    receiverPeer.id,
+		key:         keyOf(request),
+		answer:      future,
+	}
+	return future
+}
+
+// removes the open request with the given nonce
+func (pendingRequests *pendingRequests) remove(nonce []byte) {
+	pendingRequests.Lock()
+	defer pendingRequests.Unlock()
+	delete(pendingRequests.requests, string(nonce))
+}
+
+// delivers answer to the request with the same nonce
+// returns false if there is no such open request, if the answer does not come from the peer the request was sent to or
+// if its type or key does not fit to the request
+func (pendingRequests *pendingRequests) resolve(answer *p2pMessage) bool {
+	pendingRequests.Lock()
+	defer pendingRequests.Unlock()
+	request, open := pendingRequests.requests[string(answer.header.nonce)]
+	if !open || !isAnswerTo(request.messageType, answer.header.messageType) {
+		return false
+	}
+	if request.receiver != (id{}) && answer.header.senderPeer.id != request.receiver {
+		return false
+	}
+	// a KDM_FIND_NODE_ANSWER carries no key, the other answers have to be about the key of the request
+	if answer.header.messageType != KDM_FIND_NODE_ANSWER && keyOf(answer) != request.key {
+		return false
+	}
+	// the request is answered, further answers with the same nonce are rejected
+	delete(pendingRequests.requests, string(answer.header.nonce))
+	request.answer <- answer
+	return true
+}
+
+// checks if a message of type answerType is a valid answer to a request of type requestType
+func isAnswerTo(requestType uint16, answerType uint16) bool {
+	switch requestType {
+	case KDM_PING:
+		return answerType == KDM_PONG
+	case KDM_STORE:
+		return answerType == KDM_STORE_ACK
+	case KDM_FIND_NODE:
+		return answerType == KDM_FIND_NODE_ANSWER
+	case KDM_FIND_VALUE:
+		return answerType == KDM_FIND_NODE_ANSWER || answerType == KDM_FOUND_VALUE
+	}
+	return false
+}
+
+// returns the key or id a request or an answer is about, zero for messages without one
+func keyOf(m *p2pMessage) id {
+	switch body := m.body.(type) {
+	case *kdmFindNodeBody:
+		return body.id
+	case *kdmFindValueBody:
+		return body.id
+	case *kdmStoreBody:
+		return body.key
+	case *kdmStoreAckBody:
+		return body.key
+	case *kdmFoundValueBody:
+		return body.key
+	}
+	return id{}
+}
+
+// checks if messages of the given type are answers to a request
+func isAnswer(messageType uint16) bool {
+	return messageType == KDM_PONG || messageType == KDM_STORE_ACK || messageType == KDM_FIND_NODE_ANSWER || messageType == KDM_FOUND_VALUE
+}
+
+/*
+sendRequest sends the request m to the receiver peer and waits for the answer carrying the same nonce. The answer is
+expected on the same connection; it is delivered through the future of the request, so answers with an unknown nonce
+or an unexpected type are rejected. If no answer arrives within REQUEST_TIMEOUT an error is returned.
+*/
+func (thisNode *Node) sendRequest(m p2pMessage, receiverPeer peer) (*p2pMessage, error) {
+	future := thisNode.pendingRequests.add(&m, receiverPeer)
+	defer thisNode.pendingRequests.remove(m.header.nonce)
+
+	conn, err := thisNode.dialP2P(receiverPeer)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(REQUEST_TIMEOUT))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeMessage(conn, m)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the answer from the connection and hand it over to the future
+	readErrors := make(chan error, 1)
+	go func() {
+		answer, err := readMessage(conn)
+		if err == nil {
+			err = verifyMessage(answer)
+		}
+		if err == nil {
+			err = verifyConnectionIdentity(conn, answer.header.senderPeer.id)
+		}
+		if err == nil {
+			err = thisNode.verifyPuzzles(answer)
+		}
+		if err == nil {
+			err = thisNode.replayCache.check(answer, time.Now())
+		}
+		if err != nil {
+			readErrors <- err
+			return
+		}
+		if !thisNode.pendingRequests.resolve(answer) {
+			log.Error("[FAILURE] Rejected answer of type ", answer.header.messageType, " from ", answer.header.senderPeer.toString(), ": ", errUnsolicitedAnswer)
+			readErrors <- errUnsolicitedAnswer
+		}
+	}()
+
+	select {
+	case answer := <-future:
+		return answer, nil
+	case err := <-readErrors:
+		return nil, err
+	case <-time.After(REQUEST_TIMEOUT):
+		return nil, errRequestTimeout
+	}
+}
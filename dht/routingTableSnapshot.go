@@ -0,0 +1,181 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const SNAPSHOT_INTERVAL int = 300 // snapshot the routing table every 300s
+
+// snapshot of the routing table as it is written to the routing table file
+type routingTableSnapshot struct {
+	ID      string           `json:"id"` // id of the local node, hex encoded
+	Written time.Time        `json:"written"`
+	Buckets []bucketSnapshot `json:"buckets"`
+}
+
+// snapshot of one k-Bucket, its peers are ordered from least-recently to most-recently seen
+type bucketSnapshot struct {
+	Prefix     string         `json:"prefix"`
+	LastLookup time.Time      `json:"lastLookup"`
+	Peers      []peerSnapshot `json:"peers"`
+}
+
+type peerSnapshot struct {
+	ID       string    `json:"id"` // hex encoded
+	IP       string    `json:"ip"`
+	Port     uint16    `json:"port"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// takes a snapshot of the routing table of the local node
+func (thisNode *Node) snapshotRoutingTable() routingTableSnapshot {
+	thisNode.routingTreeLock.RLock()
+	defer thisNode.routingTreeLock.RUnlock()
+
+	snapshot := routingTableSnapshot{ID: hex.EncodeToString(thisNode.thisPeer.id[:]), Written: time.Now()}
+	var collect func(routingTable *routingTree)
+	collect = func(routingTable *routingTree) {
+		if routingTable.kBucket == nil {
+			collect(routingTable.left)
+			collect(routingTable.right)
+			return
+		}
+		bucket := bucketSnapshot{Prefix: routingTable.prefix, LastLookup: routingTable.lastLookup, Peers: []peerSnapshot{}}
+		for _, p := range routingTable.kBucket {
+			bucket.Peers = append(bucket.Peers, peerSnapshot{
+				ID:       hex.EncodeToString(p.id[:]),
+				IP:       p.ip,
+				Port:     p.port,
+				LastSeen: routingTable.lastSeen[p.id],
+			})
+		}
+		snapshot.Buckets = append(snapshot.Buckets, bucket)
+	}
+	collect(&thisNode.routingTree)
+	return snapshot
+}
+
+// writes a snapshot of the routing table to the routing table file, the file is replaced atomically
+func (thisNode *Node) writeRoutingTableSnapshot() error {
+	content, err := json.MarshalIndent(thisNode.snapshotRoutingTable(), "", "  ")
+	if err != nil {
+		return errors.New("Could not encode routing table snapshot: " + err.Error())
+	}
+	tmpFile := thisNode.conf.RoutingTableFile + ".tmp"
+	err = os.WriteFile(tmpFile, content, 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, thisNode.conf.RoutingTableFile)
+	}
+	if err != nil {
+		return errors.New("Could not write routing table snapshot: " + err.Error())
+	}
+	log.Debug("Wrote routing table snapshot ", thisNode.conf.RoutingTableFile)
+	return nil
+}
+
+// reads the peers of the snapshot in the routing table file, ordered from least-recently to most-recently seen, and the
+// time of the last lookup of each k-Bucket by its prefix
+func readRoutingTableSnapshot(path string) ([]peer, map[string]time.Time, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var snapshot routingTableSnapshot
+	err = json.Unmarshal(content, &snapshot)
+	if err != nil {
+		return nil, nil, errors.New("Could not decode routing table snapshot: " + err.Error())
+	}
+
+	var peers []peerSnapshot
+	lastLookups := make(map[string]time.Time)
+	for _, bucket := range snapshot.Buckets {
+		peers = append(peers, bucket.Peers...)
+		lastLookups[bucket.Prefix] = bucket.LastLookup
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].LastSeen.Before(peers[j].LastSeen)
+	})
+
+	result := make([]peer, 0, len(peers))
+	for _, p := range peers {
+		decoded, err := hex.DecodeString(p.ID)
+		if err != nil || len(decoded) != SIZE_OF_ID {
+			log.Error("[FAILURE] Skipping peer of routing table snapshot with invalid id ", p.ID)
+			continue
+		}
+		var peerID id
+		copy(peerID[:], decoded)
+		result = append(result, peer{ip: p.IP, port: p.Port, id: peerID})
+	}
+	return result, lastLookups, nil
+}
+
+/*
+restoreLastLookups sets the time of the last lookup of every k-Bucket to the one of the snapshot, so k-Buckets which
+were not looked up for a long time before the shutdown are refreshed on schedule. The k-Buckets may be split
+differently than in the snapshot: a k-Bucket gets the last lookup of the k-Bucket of the snapshot which contains its
+range, or the oldest one of the k-Buckets of the snapshot within its range. Times in the future are ignored.
+*/
+func (thisNode *Node) restoreLastLookups(lastLookups map[string]time.Time) {
+	now := time.Now()
+	thisNode.routingTreeLock.Lock()
+	defer thisNode.routingTreeLock.Unlock()
+	var restore func(routingTable *routingTree)
+	restore = func(routingTable *routingTree) {
+		if routingTable.kBucket == nil {
+			restore(routingTable.left)
+			restore(routingTable.right)
+			return
+		}
+		var restored time.Time
+		for prefix, lastLookup := range lastLookups {
+			overlapping := strings.HasPrefix(prefix, routingTable.prefix) || strings.HasPrefix(routingTable.prefix, prefix)
+			if overlapping && (restored.IsZero() || lastLookup.Before(restored)) {
+				restored = lastLookup
+			}
+		}
+		if !restored.IsZero() && restored.Before(now) {
+			routingTable.lastLookup = restored
+		}
+	}
+	restore(&thisNode.routingTree)
+}
+
+/*
+restoreRoutingTable reloads the routing table snapshot written at the last shutdown. Every peer of the snapshot is
+re-validated with a ping; only peers which answer with the id of the snapshot are inserted again, in the order in which
+they were last seen, so the least-recently seen peers stay at the beginning of their k-Buckets. Afterwards the k-Buckets
+get the last lookup times of the snapshot. Returns the number of peers which answered.
+*/
+func (thisNode *Node) restoreRoutingTable() int {
+	if thisNode.conf.RoutingTableFile == "" {
+		return 0
+	}
+	peers, lastLookups, err := readRoutingTableSnapshot(thisNode.conf.RoutingTableFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("[FAILURE] Could not restore routing table: ", err)
+		}
+		return 0
+	}
+
+	answered := 0
+	for i, answer := range thisNode.pingPeers(peers) {
+		if answer == nil {
+			continue
+		}
+		thisNode.updateRoutingTable(answeringPeer(answer, peers[i]))
+		answered++
+	}
+	thisNode.restoreLastLookups(lastLookups)
+	log.Info("[SUCCESS] Restored ", answered, " of ", len(peers), " peers of the routing table snapshot")
+	return answered
+}
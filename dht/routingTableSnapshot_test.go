@@ -0,0 +1,75 @@
+package dht
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+TestRoutingTableSnapshot writes a snapshot of a routing table with two live peers and one peer which is not reachable
+anymore. A restarted node which restores the snapshot has to re-validate the peers and may only insert the live ones,
+keeping the order in which they were last seen.
+*/
+func TestRoutingTableSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peer1 := startTestNode(t, ctx, "1100", 8031)
+	peer2 := startTestNode(t, ctx, "1000", 8032)
+	deadPeer := buildTestNode("0100", 8033)
+
+	path := filepath.Join(t.TempDir(), "routingTable.json")
+	thisNode := buildTestNode("0001", 8030)
+	thisNode.conf.RoutingTableFile = path
+	thisNode.updateRoutingTable(peer2.thisPeer)
+	thisNode.updateRoutingTable(deadPeer.thisPeer)
+	thisNode.updateRoutingTable(peer1.thisPeer)
+	// peer2 was seen most recently
+	thisNode.routingTree.markSeen(peer2.thisPeer.id, time.Now().Add(time.Second))
+	lastLookup := time.Now().Add(-time.Hour)
+	thisNode.routingTree.lastLookup = lastLookup
+	if err := thisNode.writeRoutingTableSnapshot(); err != nil {
+		t.Fatal("[FAILURE] could not write snapshot: ", err)
+	}
+
+	peers, lastLookups, err := readRoutingTableSnapshot(path)
+	if err != nil {
+		t.Fatal("[FAILURE] could not read snapshot: ", err)
+	}
+	if len(peers) != 3 || peers[0] != deadPeer.thisPeer || peers[1] != peer1.thisPeer || peers[2] != peer2.thisPeer {
+		t.Errorf("[FAILURE] snapshot does not contain the peers ordered by their last-seen time")
+	}
+	if len(lastLookups) != 1 || !lastLookups[""].Equal(lastLookup) {
+		t.Errorf("[FAILURE] snapshot does not contain the last lookup of the k-Bucket")
+	}
+
+	restarted := buildTestNode("0001", 8030)
+	restarted.conf.RoutingTableFile = path
+	if answered := restarted.restoreRoutingTable(); answered != 2 {
+		t.Errorf("[FAILURE] %d instead of 2 peers were restored", answered)
+	}
+	bucket := restarted.routingTree.kBucket
+	if len(bucket) != 2 || bucket[0] != peer1.thisPeer || bucket[1] != peer2.thisPeer {
+		t.Errorf("[FAILURE] restored routing table does not contain the live peers in last-seen order")
+	}
+	if !restarted.routingTree.lastLookup.Equal(lastLookup) {
+		t.Errorf("[FAILURE] last lookup of the k-Bucket was not restored")
+	}
+
+	// k-Buckets split differently than in the snapshot get the last lookup of the overlapping k-Buckets
+	split := buildEmptyTestRoutingTree()
+	split.split()
+	restarted.routingTree = *split
+	restarted.restoreLastLookups(map[string]time.Time{"0": lastLookup, "10": lastLookup.Add(time.Minute), "11": lastLookup.Add(2 * time.Minute)})
+	if !restarted.routingTree.left.lastLookup.Equal(lastLookup) || !restarted.routingTree.right.lastLookup.Equal(lastLookup.Add(time.Minute)) {
+		t.Errorf("[FAILURE] last lookups of the snapshot were not mapped onto the k-Buckets")
+	}
+
+	// a missing snapshot is no error
+	restarted.conf.RoutingTableFile = filepath.Join(t.TempDir(), "missing.json")
+	if restarted.restoreRoutingTable() != 0 {
+		t.Errorf("[FAILURE] missing snapshot must not restore any peer")
+	}
+}
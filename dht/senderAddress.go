@@ -0,0 +1,113 @@
+package dht
+
+import (
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+The address in the header of a p2pMessage is chosen by its sender, so it is not trusted blindly before it is put into
+the routing table:
+  - the senders of requests have to connect from the IP address they claim, otherwise they are still answered but not
+    added to the routing table
+  - a sender which is not in the routing table yet is only added after it answered a KDM_PING sent to the claimed port
+  - peers which answer our requests are added with the address they were reached at
+Without these checks any host could make us store and hand out the address of a third party under an arbitrary id.
+*/
+
+// maximum number of senders which are verified with a KDM_PING at the same time, further new senders are ignored
+const MAX_PENDING_VERIFICATIONS = 64
+
+// struct which remembers the senders whose address is currently verified, the zero value is ready to use
+type pendingVerifications struct {
+	peers map[id]bool
+	sync.Mutex
+}
+
+// marks the verification of p as started, returns false if it is already running or too many are running
+func (verifications *pendingVerifications) start(p peer) bool {
+	verifications.Lock()
+	defer verifications.Unlock()
+	if verifications.peers == nil {
+		verifications.peers = make(map[id]bool)
+	}
+	if verifications.peers[p.id] || len(verifications.peers) >= MAX_PENDING_VERIFICATIONS {
+		return false
+	}
+	verifications.peers[p.id] = true
+	return true
+}
+
+// marks the verification of p as finished
+func (verifications *pendingVerifications) finish(p peer) {
+	verifications.Lock()
+	defer verifications.Unlock()
+	delete(verifications.peers, p.id)
+}
+
+// returns the number of verifications which are running
+func (verifications *pendingVerifications) running() int {
+	verifications.Lock()
+	defer verifications.Unlock()
+	return len(verifications.peers)
+}
+
+// checks if the IP address claimed by sender is the one the connection comes from
+func addressMatches(remoteAddress net.Addr, sender peer) bool {
+	tcpAddress, ok := remoteAddress.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	claimed := net.ParseIP(sender.ip)
+	return claimed != nil && claimed.Equal(tcpAddress.IP)
+}
+
+// returns the peer which sent answer, with the address at which it was reached instead of the claimed one
+func answeringPeer(answer *p2pMessage, receiverPeer peer) peer {
+	return peer{id: answer.header.senderPeer.id, ip: receiverPeer.ip, port: receiverPeer.port}
+}
+
+/*
+learnSender updates the routing table with the sender of a request received on conn. Senders whose claimed IP address
+differs from the one of the connection are ignored. Known senders are marked as seen, new ones are pinged at their
+claimed address in the background and only added if they answer with the same id. The ping is tracked by the wait group
+of the node and neither started nor followed by an update of the routing table once the node is closing.
+*/
+func (thisNode *Node) learnSender(conn net.Conn, sender peer) {
+	if !addressMatches(conn.RemoteAddr(), sender) {
+		log.Debug("Did not add ", sender.toString(), " to the routing table: connection comes from ", conn.RemoteAddr())
+		return
+	}
+
+	thisNode.routingTreeLock.RLock()
+	known := thisNode.findResponsibleRoutingTree(sender.id).kBucket.contains(sender.id)
+	thisNode.routingTreeLock.RUnlock()
+	if known || sender.id == thisNode.thisPeer.id {
+		thisNode.updateRoutingTable(sender)
+		return
+	}
+
+	if thisNode.closing() || !thisNode.verifications.start(sender) {
+		return
+	}
+	thisNode.wg.Add(1)
+	go func() {
+		defer thisNode.wg.Done()
+		defer thisNode.verifications.finish(sender)
+		answer, err := thisNode.sendRequest(thisNode.makeP2PMessageOutOfBody(nil, KDM_PING), sender)
+		if err != nil {
+			log.Debug("Did not add ", sender.toString(), " to the routing table: ", err)
+			return
+		}
+		if answer.header.senderPeer.id != sender.id {
+			log.Debug("Did not add ", sender.toString(), " to the routing table: another peer answered at its address")
+			return
+		}
+		if thisNode.closing() {
+			return
+		}
+		thisNode.updateRoutingTable(sender)
+	}()
+}
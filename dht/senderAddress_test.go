@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// waits until all senders which are verified with a KDM_PING were added or rejected
+func waitForVerifications(t *testing.T, thisNode *Node) {
+	deadline := time.Now().Add(2 * REQUEST_TIMEOUT)
+	for thisNode.verifications.running() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("[FAILURE] verifications of senders did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test if the claimed IP address of a sender is compared with the address of the connection
+func TestAddressMatches(t *testing.T) {
+	sender := peer{ip: "127.0.0.1", port: 4444}
+	if !addressMatches(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}, sender) {
+		t.Errorf("[FAILURE] matching address was rejected")
+	}
+	if addressMatches(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000}, sender) {
+		t.Errorf("[FAILURE] address of another host was accepted")
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if addressMatches(server.RemoteAddr(), sender) {
+		t.Errorf("[FAILURE] connection without IP address was accepted")
+	}
+}
+
+// Test if only senders that connect from their claimed address and answer at their claimed port are added
+func TestLearnSender(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := startTestNode(t, ctx, "1", 8100)
+
+	// the sender answers the verification ping
+	sender := startTestNode(t, ctx, "01", 8101)
+	if !sender.pingNode(receiver.thisPeer) {
+		t.Fatal("[FAILURE] ping failed")
+	}
+	waitForVerifications(t, receiver)
+	if known := receiver.knownPeers(); len(known) != 1 || known[0].id != sender.thisPeer.id {
+		t.Errorf("[FAILURE] verified sender was not added to the routing table")
+	}
+
+	// the sender claims the address of another host
+	spoofing := buildTestNode("001", 8102)
+	spoofing.thisPeer.ip = "10.1.2.3"
+	if !spoofing.pingNode(receiver.thisPeer) {
+		t.Fatal("[FAILURE] ping with a foreign address was not answered")
+	}
+	// nobody answers at the claimed port
+	unreachable := buildTestNode("0001", 8103)
+	if !unreachable.pingNode(receiver.thisPeer) {
+		t.Fatal("[FAILURE] ping failed")
+	}
+	waitForVerifications(t, receiver)
+	if len(receiver.knownPeers()) != 1 {
+		t.Errorf("[FAILURE] sender with an unverified address was added to the routing table")
+	}
+}
+
+// Test if a closing node does not verify new senders, which would change the routing table after Close
+func TestLearnSenderWhileClosing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := startTestNode(t, ctx, "01", 8123)
+
+	receiver := buildTestNode("1", 8124)
+	receiver.ctx, receiver.cancel = context.WithCancel(context.Background())
+	receiver.cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:8124")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal("[FAILURE] could not connect: ", err)
+	}
+	defer client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal("[FAILURE] could not accept: ", err)
+	}
+	defer conn.Close()
+
+	receiver.learnSender(conn, sender.thisPeer)
+	receiver.wg.Wait()
+	waitForVerifications(t, receiver)
+	if len(receiver.knownPeers()) != 0 {
+		t.Errorf("[FAILURE] closing node added a new sender to the routing table")
+	}
+}
@@ -0,0 +1,138 @@
+package dht
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+Every p2pMessage is signed with the host key of its sender. The signature covers the header and the body (the data of
+the message) and is sent after the data, together with the public key of the sender and its solution of the dynamic
+puzzle (see puzzles.go):
+
+	publicKeySize (2) | publicKey (PKIX, DER) | signatureSize (2) | signature | puzzleSolution (32)
+
+As the ID of a peer is the SHA-256 hash of its public key, a receiver can check that the public key belongs to the
+senderPeer of the header and that the sender owns the private key. RSA keys sign with PKCS #1 v1.5 over the SHA-256
+hash of the data, Ed25519 keys sign the data directly.
+*/
+
+// upper bounds for the size of the public key and the signature, enough for RSA keys with 8192 bits
+const MAX_PUBLIC_KEY_SIZE = 1100
+const MAX_SIGNATURE_SIZE = 1024
+
+// errors returned by verifyMessage
+var (
+	errMissingSignature  = errors.New("message is not signed")
+	errSenderIdMismatch  = errors.New("public key does not belong to the id of the sender")
+	errInvalidSignature  = errors.New("signature is invalid")
+	errUnsupportedKey    = errors.New("public key has an unsupported type")
+	errSignatureTooLarge = errors.New("public key or signature is larger than allowed")
+)
+
+// returns the ID belonging to a public key in PKIX, DER encoding: its SHA-256 hash
+func idOfPublicKey(publicKeyDer []byte) id {
+	return sha256.Sum256(publicKeyDer)
+}
+
+// signs data with the given key, see above for the algorithms used
+func sign(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// signs message m with the host key of the local node, every node has one as peers drop unsigned messages
+func (thisNode *Node) signMessage(m *p2pMessage) {
+	if thisNode.hostKey == nil {
+		panic("node has no host key to sign messages with")
+	}
+	signature, err := sign(thisNode.hostKey, m.data)
+	if err != nil {
+		panic(err.Error())
+	}
+	m.publicKey = thisNode.publicKey
+	m.signature = signature
+}
+
+// checks that message m is signed by the owner of the id of its senderPeer
+func verifyMessage(m *p2pMessage) error {
+	if len(m.publicKey) == 0 || len(m.signature) == 0 {
+		return errMissingSignature
+	}
+	if idOfPublicKey(m.publicKey) != m.header.senderPeer.id {
+		return errSenderIdMismatch
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(m.publicKey)
+	if err != nil {
+		return errors.New("Could not parse public key: " + err.Error())
+	}
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(m.data)
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], m.signature) != nil {
+			return errInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(publicKey, m.data, m.signature) {
+			return errInvalidSignature
+		}
+	default:
+		return errUnsupportedKey
+	}
+	return nil
+}
+
+// encodes the public key, the signature and the puzzle solution of message m, which are sent after its data
+func encodeSignature(m *p2pMessage) []byte {
+	result := make([]byte, 2, 4+len(m.publicKey)+len(m.signature)+SIZE_OF_ID)
+	binary.BigEndian.PutUint16(result, uint16(len(m.publicKey)))
+	result = append(result, m.publicKey...)
+	signatureSize := make([]byte, 2)
+	binary.BigEndian.PutUint16(signatureSize, uint16(len(m.signature)))
+	result = append(result, signatureSize...)
+	result = append(result, m.signature...)
+	result = append(result, m.puzzleSolution[:]...)
+	return result
+}
+
+// reads the public key, the signature and the puzzle solution sent after the data of message m
+func readSignature(reader io.Reader, m *p2pMessage) error {
+	var err error
+	m.publicKey, err = readSizedField(reader, MAX_PUBLIC_KEY_SIZE)
+	if err != nil {
+		return err
+	}
+	m.signature, err = readSizedField(reader, MAX_SIGNATURE_SIZE)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(reader, m.puzzleSolution[:])
+	return err
+}
+
+// reads a field which is preceded by its size (2 bytes)
+func readSizedField(reader io.Reader, maxSize int) ([]byte, error) {
+	sizeField := make([]byte, 2)
+	if _, err := io.ReadFull(reader, sizeField); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(sizeField))
+	if size > maxSize {
+		return nil, errSignatureTooLarge
+	}
+	field := make([]byte, size)
+	if _, err := io.ReadFull(reader, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
@@ -0,0 +1,79 @@
+package dht
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// Test if a signed message is still valid after it was sent and detects tampering and foreign sender ids
+func TestMessageSignature(t *testing.T) {
+	sender := buildTestNode("1", 3333)
+	m := sender.makeP2PMessageOutOfBody(&kdmStoreBody{key: buildTestIdFromString("11"), ttl: 20, value: []byte("value")}, KDM_STORE)
+
+	var buffer bytes.Buffer
+	if err := writeMessage(&buffer, m); err != nil {
+		t.Fatal("[FAILURE] could not write message: ", err)
+	}
+	received, err := readMessage(&buffer)
+	if err != nil {
+		t.Fatal("[FAILURE] could not read message: ", err)
+	}
+	if err = verifyMessage(received); err != nil {
+		t.Errorf("[FAILURE] signature of a sent message is invalid: %v", err)
+	}
+
+	// the value is changed on the way
+	received.data[len(received.data)-1] ^= 1
+	if verifyMessage(received) != errInvalidSignature {
+		t.Errorf("[FAILURE] tampered message has to be rejected")
+	}
+
+	// another node claims to be the sender
+	impostor := buildTestNode("0", 4444)
+	impostor.thisPeer.id = sender.thisPeer.id
+	forged := impostor.makeP2PMessageOutOfBody(nil, KDM_PING)
+	if verifyMessage(&forged) != errSenderIdMismatch {
+		t.Errorf("[FAILURE] message with the id of another peer has to be rejected")
+	}
+
+	unsigned := sender.makeP2PMessageOutOfBody(nil, KDM_PING)
+	unsigned.publicKey, unsigned.signature = nil, nil
+	if verifyMessage(&unsigned) != errMissingSignature {
+		t.Errorf("[FAILURE] unsigned message has to be rejected")
+	}
+}
+
+// Test if messages signed with an RSA host key are verified
+func TestRSAMessageSignature(t *testing.T) {
+	hostKey, publicKey, err := readHostKey("../config/mainHostkey.pem")
+	if err != nil {
+		t.Fatal("[FAILURE] could not read host key: ", err)
+	}
+	sender := Node{hostKey: hostKey, publicKey: publicKey, thisPeer: peer{id: idOfPublicKey(publicKey)}}
+	m := sender.makeP2PMessageOutOfBody(&kdmFindNodeBody{id: buildTestIdFromString("1")}, KDM_FIND_NODE)
+	if err = verifyMessage(&m); err != nil {
+		t.Errorf("[FAILURE] RSA signature is invalid: %v", err)
+	}
+}
+
+// Test if a message with an invalid signature is dropped before the sender is added to the routing table
+func TestDropUnsignedMessage(t *testing.T) {
+	receiver := buildTestNode("1", 3333)
+	client, server := net.Pipe()
+	defer client.Close()
+	go receiver.handleP2PConnection(server)
+
+	sender := buildTestNode("01", 4444)
+	request := sender.makeP2PMessageOutOfBody(&kdmFindNodeBody{id: buildTestIdFromString("011")}, KDM_FIND_NODE)
+	request.publicKey, request.signature = nil, nil
+	if err := writeMessage(client, request); err != nil {
+		t.Fatal("[FAILURE] could not write message: ", err)
+	}
+	if _, err := readMessage(client); err == nil {
+		t.Errorf("[FAILURE] unsigned request was answered")
+	}
+	if len(receiver.knownPeers()) != 0 {
+		t.Errorf("[FAILURE] sender of an unsigned message was added to the routing table")
+	}
+}
@@ -0,0 +1,399 @@
+package dht
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// <key, value>-pair of the local data storage together with its expiration and republishing time
+type storageEntry struct {
+	value            []byte
+	expiration       time.Time
+	republishingTime time.Time
+	replication      uint8 // requested replication degree, 0 if not specified
+	cached           bool  // copy cached on a lookup path, which is never replicated, handed over or republished
+}
+
+// returns the remaining TTL of the entry in seconds, rounded up and bounded by the size of the ttl field of a
+// KDM_STORE, and false if the entry is already expired
+func (entry storageEntry) remainingTTL(now time.Time) (uint16, bool) {
+	remaining := entry.expiration.Sub(now)
+	if remaining <= 0 {
+		return 0, false
+	}
+	ttl := (remaining + time.Second - 1) / time.Second
+	if ttl > math.MaxUint16 {
+		ttl = math.MaxUint16
+	}
+	return uint16(ttl), true
+}
+
+/*
+storage is the backend of the hashTable, which keeps the <key, value>-pairs the local node is responsible for.
+Implementations have to be safe for concurrent use.
+*/
+type storage interface {
+	// returns the entry stored for key and a boolean if there is one
+	read(key id) (storageEntry, bool)
+	// stores entry for key, replacing an existing entry
+	write(key id, entry storageEntry) error
+	// removes all entries which expired before now
+	expire(now time.Time) error
+	// returns all entries whose republishing time lies before now and sets their republishing time to next, so they
+	// are handed out only once per republishing interval; the returned entries carry the new republishing time
+	takeDueForRepublishing(now time.Time, next time.Time) map[id]storageEntry
+	// returns all entries
+	readAll() map[id]storageEntry
+	// releases the resources of the storage
+	close() error
+}
+
+// storage which only keeps the entries in memory, they are lost when the node stops
+// expired and due entries are found through deadline queues, so only they are touched by expire and
+// takeDueForRepublishing
+type memoryStorage struct {
+	entries      map[id]storageEntry
+	expirations  deadlineQueue
+	republishing deadlineQueue
+	sync.RWMutex
+}
+
+// the deadline queues are rebuilt as soon as they contain REBUILD_FACTOR times more deadlines than entries (but at
+// least MIN_DEADLINES_FOR_REBUILD deadlines), so outdated deadlines of rewritten or removed entries do not pile up
+const REBUILD_FACTOR = 2
+const MIN_DEADLINES_FOR_REBUILD = 1024
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{entries: make(map[id]storageEntry)}
+}
+
+func (memoryStorage *memoryStorage) read(key id) (storageEntry, bool) {
+	memoryStorage.RLock()
+	defer memoryStorage.RUnlock()
+	entry, existing := memoryStorage.entries[key]
+	return entry, existing
+}
+
+func (memoryStorage *memoryStorage) write(key id, entry storageEntry) error {
+	memoryStorage.Lock()
+	defer memoryStorage.Unlock()
+	memoryStorage.put(key, entry)
+	return nil
+}
+
+// stores entry for key and schedules its deadlines; the lock has to be held
+func (memoryStorage *memoryStorage) put(key id, entry storageEntry) {
+	memoryStorage.entries[key] = entry
+	memoryStorage.expirations.schedule(key, entry.expiration)
+	memoryStorage.republishing.schedule(key, entry.republishingTime)
+
+	limit := REBUILD_FACTOR * len(memoryStorage.entries)
+	if limit < MIN_DEADLINES_FOR_REBUILD {
+		limit = MIN_DEADLINES_FOR_REBUILD
+	}
+	if len(memoryStorage.expirations) > limit {
+		memoryStorage.expirations.rebuild(memoryStorage.entries, func(entry storageEntry) time.Time { return entry.expiration })
+	}
+	if len(memoryStorage.republishing) > limit {
+		memoryStorage.republishing.rebuild(memoryStorage.entries, func(entry storageEntry) time.Time { return entry.republishingTime })
+	}
+}
+
+// removes all entries which expired before now and returns their keys; the lock has to be held
+func (memoryStorage *memoryStorage) removeExpired(now time.Time) []id {
+	var expired []id
+	for {
+		due, ok := memoryStorage.expirations.popDue(now)
+		if !ok {
+			return expired
+		}
+		// the deadline is outdated if the entry was removed or written again in the meantime
+		if entry, existing := memoryStorage.entries[due.key]; existing && entry.expiration.Equal(due.at) {
+			delete(memoryStorage.entries, due.key)
+			expired = append(expired, due.key)
+		}
+	}
+}
+
+// sets the republishing time of all entries due before now to next and returns them; the lock has to be held
+func (memoryStorage *memoryStorage) rescheduleDue(now time.Time, next time.Time) map[id]storageEntry {
+	result := make(map[id]storageEntry)
+	for {
+		due, ok := memoryStorage.republishing.popDue(now)
+		if !ok {
+			return result
+		}
+		if entry, existing := memoryStorage.entries[due.key]; existing && entry.republishingTime.Equal(due.at) {
+			entry.republishingTime = next
+			memoryStorage.put(due.key, entry)
+			result[due.key] = entry
+		}
+	}
+}
+
+func (memoryStorage *memoryStorage) expire(now time.Time) error {
+	memoryStorage.Lock()
+	defer memoryStorage.Unlock()
+	memoryStorage.removeExpired(now)
+	return nil
+}
+
+func (memoryStorage *memoryStorage) takeDueForRepublishing(now time.Time, next time.Time) map[id]storageEntry {
+	memoryStorage.Lock()
+	defer memoryStorage.Unlock()
+	return memoryStorage.rescheduleDue(now, next)
+}
+
+func (memoryStorage *memoryStorage) readAll() map[id]storageEntry {
+	memoryStorage.RLock()
+	defer memoryStorage.RUnlock()
+	result := make(map[id]storageEntry, len(memoryStorage.entries))
+	for key, entry := range memoryStorage.entries {
+		result[key] = entry
+	}
+	return result
+}
+
+func (memoryStorage *memoryStorage) close() error {
+	return nil
+}
+
+// types of the records of the log of a diskStorage
+const (
+	recordWrite       byte = 1
+	recordDelete      byte = 2
+	recordWriteCached byte = 3 // recordWrite of an entry cached on a lookup path
+)
+
+// a diskStorage log is compacted as soon as it contains COMPACTION_FACTOR times more records than live entries
+// (but at least MIN_RECORDS_FOR_COMPACTION records)
+const COMPACTION_FACTOR = 2
+const MIN_RECORDS_FOR_COMPACTION = 1024
+
+// size of a record without the value: type(1), key, expiration(8), republishingTime(8), replication(1), valueSize(4)
+// followed by the value and a CRC-32 checksum(4) of everything before
+const SIZE_OF_RECORD_HEADER = 1 + SIZE_OF_ID + 8 + 8 + 1 + 4
+
+/*
+diskStorage keeps all entries in memory and additionally appends every change as a record to a log file. On startup the
+log is replayed, so entries survive restarts together with their expiration and republishing times. A record at the end
+of the log which was only written partially (e.g. because of a crash) is discarded. When the log contains too many
+outdated records, it is compacted by rewriting it with one record per live entry.
+Records are handed to the operating system immediately, but only synced to disk on compaction and on close.
+*/
+type diskStorage struct {
+	path    string
+	file    *os.File
+	records int // number of records in the log
+	memoryStorage
+}
+
+// opens the log file at the given path, creating it if it does not exist yet, and replays it
+func openDiskStorage(path string) (*diskStorage, error) {
+	diskStorage := &diskStorage{path: path}
+	diskStorage.entries = make(map[id]storageEntry)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.New("Could not open storage file: " + err.Error())
+	}
+	validSize, err := diskStorage.replay(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.New("Could not read storage file: " + err.Error())
+	}
+	// discard a partially written record at the end of the log
+	err = file.Truncate(validSize)
+	if err == nil {
+		_, err = file.Seek(validSize, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.New("Could not repair storage file: " + err.Error())
+	}
+	diskStorage.file = file
+	return diskStorage, nil
+}
+
+// applies all complete records of the log to the entries and returns the size of the valid part of the log
+func (diskStorage *diskStorage) replay(file *os.File) (int64, error) {
+	reader := bufio.NewReader(file)
+	var validSize int64
+	for {
+		recordType, key, entry, size, err := readRecord(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorruptRecord {
+			return validSize, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		if recordType == recordWrite {
+			diskStorage.put(key, entry)
+		} else {
+			delete(diskStorage.entries, key)
+		}
+		diskStorage.records++
+		validSize += int64(size)
+	}
+}
+
+var errCorruptRecord = errors.New("record of storage log is corrupt")
+
+// reads one record of the log and returns its type, key, entry and size
+func readRecord(reader io.Reader) (byte, id, storageEntry, int, error) {
+	var key id
+	header := make([]byte, SIZE_OF_RECORD_HEADER)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, key, storageEntry{}, 0, err
+	}
+	recordType := header[0]
+	valueSize := binary.BigEndian.Uint32(header[SIZE_OF_RECORD_HEADER-4:])
+	if (recordType != recordWrite && recordType != recordDelete && recordType != recordWriteCached) || valueSize > uint32(maxMessageLength) {
+		return 0, key, storageEntry{}, 0, errCorruptRecord
+	}
+	rest := make([]byte, int(valueSize)+4)
+	if _, err := io.ReadFull(reader, rest); err != nil {
+		return 0, key, storageEntry{}, 0, err
+	}
+	checksum := crc32.NewIEEE()
+	checksum.Write(header)
+	checksum.Write(rest[:valueSize])
+	if checksum.Sum32() != binary.BigEndian.Uint32(rest[valueSize:]) {
+		return 0, key, storageEntry{}, 0, errCorruptRecord
+	}
+
+	copy(key[:], header[1:1+SIZE_OF_ID])
+	i := 1 + SIZE_OF_ID
+	entry := storageEntry{
+		expiration:       time.Unix(0, int64(binary.BigEndian.Uint64(header[i:i+8]))),
+		republishingTime: time.Unix(0, int64(binary.BigEndian.Uint64(header[i+8:i+16]))),
+		replication:      header[i+16],
+		value:            rest[:valueSize],
+	}
+	if recordType == recordWriteCached {
+		entry.cached = true
+		recordType = recordWrite
+	}
+	return recordType, key, entry, len(header) + len(rest), nil
+}
+
+// encodes a record of the log
+func encodeRecord(recordType byte, key id, entry storageEntry) []byte {
+	if recordType == recordWrite && entry.cached {
+		recordType = recordWriteCached
+	}
+	record := make([]byte, SIZE_OF_RECORD_HEADER, SIZE_OF_RECORD_HEADER+len(entry.value)+4)
+	record[0] = recordType
+	copy(record[1:], key[:])
+	i := 1 + SIZE_OF_ID
+	binary.BigEndian.PutUint64(record[i:i+8], uint64(entry.expiration.UnixNano()))
+	binary.BigEndian.PutUint64(record[i+8:i+16], uint64(entry.republishingTime.UnixNano()))
+	record[i+16] = entry.replication
+	binary.BigEndian.PutUint32(record[i+17:], uint32(len(entry.value)))
+	record = append(record, entry.value...)
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(record))
+	return append(record, checksum...)
+}
+
+func (diskStorage *diskStorage) write(key id, entry storageEntry) error {
+	diskStorage.Lock()
+	defer diskStorage.Unlock()
+	if _, err := diskStorage.file.Write(encodeRecord(recordWrite, key, entry)); err != nil {
+		return errors.New("Could not write to storage file: " + err.Error())
+	}
+	diskStorage.records++
+	diskStorage.put(key, entry)
+	return diskStorage.compactIfNeeded()
+}
+
+func (diskStorage *diskStorage) expire(now time.Time) error {
+	diskStorage.Lock()
+	defer diskStorage.Unlock()
+	for _, key := range diskStorage.removeExpired(now) {
+		if _, err := diskStorage.file.Write(encodeRecord(recordDelete, key, storageEntry{})); err != nil {
+			return errors.New("Could not write to storage file: " + err.Error())
+		}
+		diskStorage.records++
+	}
+	return diskStorage.compactIfNeeded()
+}
+
+func (diskStorage *diskStorage) takeDueForRepublishing(now time.Time, next time.Time) map[id]storageEntry {
+	diskStorage.Lock()
+	defer diskStorage.Unlock()
+	result := diskStorage.rescheduleDue(now, next)
+	for key, entry := range result {
+		// the new republishing time is persisted, so the entry is not republished again right after a restart
+		if _, err := diskStorage.file.Write(encodeRecord(recordWrite, key, entry)); err != nil {
+			log.Error("[FAILURE] Could not persist the republishing times of ", len(result), " keys: ", err)
+			break
+		}
+		diskStorage.records++
+	}
+	if err := diskStorage.compactIfNeeded(); err != nil {
+		log.Error("[FAILURE] Could not compact storage file: ", err)
+	}
+	return result
+}
+
+// compacts the log if it contains too many outdated records; the lock has to be held
+func (diskStorage *diskStorage) compactIfNeeded() error {
+	if diskStorage.records < MIN_RECORDS_FOR_COMPACTION || diskStorage.records < COMPACTION_FACTOR*len(diskStorage.entries) {
+		return nil
+	}
+	return diskStorage.compact()
+}
+
+// rewrites the log with one record per live entry and replaces the old log atomically; the lock has to be held
+func (diskStorage *diskStorage) compact() error {
+	tmpPath := diskStorage.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.New("Could not compact storage file: " + err.Error())
+	}
+	writer := bufio.NewWriter(tmpFile)
+	for key, entry := range diskStorage.entries {
+		if _, err = writer.Write(encodeRecord(recordWrite, key, entry)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, diskStorage.path)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return errors.New("Could not compact storage file: " + err.Error())
+	}
+
+	diskStorage.file.Close()
+	diskStorage.file = tmpFile
+	diskStorage.records = len(diskStorage.entries)
+	return nil
+}
+
+func (diskStorage *diskStorage) close() error {
+	diskStorage.Lock()
+	defer diskStorage.Unlock()
+	err := diskStorage.file.Sync()
+	if closeErr := diskStorage.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
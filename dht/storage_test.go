@@ -0,0 +1,189 @@
+package dht
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// checks the behavior every storage backend has to provide
+func helpTestStorage(t *testing.T, s storage) {
+	now := time.Now()
+	key1 := buildTestIdFromString("1")
+	key2 := buildTestIdFromString("01")
+	entry1 := storageEntry{value: []byte("value1"), expiration: now.Add(time.Hour), republishingTime: now.Add(-time.Second), replication: 3}
+	entry2 := storageEntry{value: []byte("value2"), expiration: now.Add(-time.Second), republishingTime: now.Add(time.Hour)}
+
+	if _, ok := s.read(key1); ok {
+		t.Errorf("[FAILURE] empty storage must not contain a key")
+	}
+	if err := s.write(key1, entry1); err != nil {
+		t.Fatal("[FAILURE] could not write: ", err)
+	}
+	if err := s.write(key2, entry2); err != nil {
+		t.Fatal("[FAILURE] could not write: ", err)
+	}
+	if entry, ok := s.read(key1); !ok || !reflect.DeepEqual(entry.value, entry1.value) || entry.replication != 3 {
+		t.Errorf("[FAILURE] written entry was not read correctly")
+	}
+
+	next := now.Add(time.Hour)
+	due := s.takeDueForRepublishing(now, next)
+	if len(due) != 1 || !reflect.DeepEqual(due[key1].value, entry1.value) || !due[key1].republishingTime.Equal(next) {
+		t.Errorf("[FAILURE] only key1 is due for republishing")
+	}
+	if len(s.takeDueForRepublishing(now, next)) != 0 {
+		t.Errorf("[FAILURE] key1 is due for republishing again before its new republishing time")
+	}
+	if entry, _ := s.read(key1); !entry.republishingTime.Equal(next) {
+		t.Errorf("[FAILURE] republishing time of key1 was not reset")
+	}
+
+	if err := s.expire(now); err != nil {
+		t.Fatal("[FAILURE] could not expire: ", err)
+	}
+	if _, ok := s.read(key2); ok {
+		t.Errorf("[FAILURE] expired key was not removed")
+	}
+	if _, ok := s.read(key1); !ok {
+		t.Errorf("[FAILURE] key which is not expired was removed")
+	}
+
+	// deadlines of an entry which was written again are outdated
+	key3 := buildTestIdFromString("001")
+	s.write(key3, storageEntry{value: []byte("value3"), expiration: now.Add(-time.Second), republishingTime: now.Add(-time.Second)})
+	s.write(key3, storageEntry{value: []byte("value3"), expiration: now.Add(time.Hour), republishingTime: now.Add(time.Hour)})
+	s.expire(now)
+	if _, ok := s.read(key3); !ok {
+		t.Errorf("[FAILURE] key was removed because of an outdated expiration")
+	}
+	if len(s.takeDueForRepublishing(now, next)) != 0 {
+		t.Errorf("[FAILURE] key was republished because of an outdated republishing time")
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	helpTestStorage(t, newMemoryStorage())
+}
+
+func TestDiskStorage(t *testing.T) {
+	s, err := openDiskStorage(filepath.Join(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatal("[FAILURE] could not open storage: ", err)
+	}
+	defer s.close()
+	helpTestStorage(t, s)
+}
+
+// Test if entries of a diskStorage survive a restart together with their expiration and republishing times
+func TestDiskStorageRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage")
+	s, err := openDiskStorage(path)
+	if err != nil {
+		t.Fatal("[FAILURE] could not open storage: ", err)
+	}
+	now := time.Now()
+	key1 := buildTestIdFromString("1")
+	key2 := buildTestIdFromString("01")
+	entry := storageEntry{value: []byte("value"), expiration: now.Add(time.Hour), republishingTime: now.Add(time.Minute), replication: 7}
+	s.write(key1, entry)
+	s.write(key2, storageEntry{value: []byte("expired"), expiration: now.Add(-time.Second)})
+	s.expire(now)
+	if err = s.close(); err != nil {
+		t.Fatal("[FAILURE] could not close storage: ", err)
+	}
+
+	// a partially written record at the end of the log has to be discarded
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal("[FAILURE] could not open storage file: ", err)
+	}
+	file.Write(encodeRecord(recordWrite, key2, entry)[:20])
+	file.Close()
+
+	s, err = openDiskStorage(path)
+	if err != nil {
+		t.Fatal("[FAILURE] could not reopen storage: ", err)
+	}
+	restored, ok := s.read(key1)
+	if !ok || !reflect.DeepEqual(restored.value, entry.value) || restored.replication != entry.replication ||
+		!restored.expiration.Equal(entry.expiration) || !restored.republishingTime.Equal(entry.republishingTime) {
+		t.Errorf("[FAILURE] entry was not restored with its expiration and republishing time")
+	}
+	if _, ok = s.read(key2); ok {
+		t.Errorf("[FAILURE] removed entry was restored")
+	}
+
+	// the storage is still writable after the partial record was discarded
+	cachedEntry := entry
+	cachedEntry.cached = true
+	s.write(key2, cachedEntry)
+	s.close()
+	s, err = openDiskStorage(path)
+	if err != nil {
+		t.Fatal("[FAILURE] could not reopen storage: ", err)
+	}
+	defer s.close()
+	if restored, ok = s.read(key2); !ok || !restored.cached {
+		t.Errorf("[FAILURE] cached entry written after repairing the log was not restored as cached")
+	}
+	if restored, _ = s.read(key1); restored.cached {
+		t.Errorf("[FAILURE] entry was restored as cached")
+	}
+}
+
+// Test if the log of a diskStorage is compacted when it contains mostly outdated records
+func TestDiskStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage")
+	s, err := openDiskStorage(path)
+	if err != nil {
+		t.Fatal("[FAILURE] could not open storage: ", err)
+	}
+	key := buildTestIdFromString("1")
+	for i := 0; i < MIN_RECORDS_FOR_COMPACTION+10; i++ {
+		if err = s.write(key, storageEntry{value: []byte{byte(i)}, expiration: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal("[FAILURE] could not write: ", err)
+		}
+	}
+	if s.records > MIN_RECORDS_FOR_COMPACTION {
+		t.Errorf("[FAILURE] log was not compacted, it contains %d records", s.records)
+	}
+	s.close()
+
+	s, err = openDiskStorage(path)
+	if err != nil {
+		t.Fatal("[FAILURE] could not reopen storage: ", err)
+	}
+	defer s.close()
+	if entry, ok := s.read(key); !ok || entry.value[0] != byte((MIN_RECORDS_FOR_COMPACTION+9)%256) {
+		t.Errorf("[FAILURE] latest entry was not restored after compaction")
+	}
+}
+
+/*
+BenchmarkStorageTick measures what the timers do with the storage every second: removing the expired entries and taking
+the entries due for republishing. As only due entries are touched, the cost of a tick does not depend on the number of
+stored entries.
+*/
+func BenchmarkStorageTick(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(strconv.Itoa(size)+" keys", func(b *testing.B) {
+			s := newMemoryStorage()
+			now := time.Now()
+			for i := 0; i < size; i++ {
+				var key id
+				binary.BigEndian.PutUint64(key[:], uint64(i))
+				s.write(key, storageEntry{value: []byte("value"), expiration: now.Add(time.Duration(i+1) * time.Hour), republishingTime: now.Add(time.Duration(i+1) * time.Minute)})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.expire(now)
+				s.takeDueForRepublishing(now, now.Add(time.Hour))
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+)
+
+// transports for the P2P communication, selected with the transport key of the ini file
+const (
+	TRANSPORT_TCP = "tcp" // plain TCP, the default
+	TRANSPORT_TLS = "tls" // mutual TLS 1.3 authenticated with the host keys
+)
+
+/*
+With TRANSPORT_TLS all P2P connections use TLS 1.3, in which both sides present a self-signed certificate for their host
+key. The handshake proves that each side owns the private key of its certificate; the SHA-256 hash of the public key of
+the certificate is the ID of the peer. A connecting node checks this ID against the ID of the peer it wants to reach (if
+it is known already, bootstrap peers are contacted without), both sides check it against the senderPeer of every
+message received on the connection. No certificate authority is involved, the IDs are the identities.
+*/
+
+// errors returned if the TLS peer does not have the expected identity
+var (
+	errNoPeerCertificate   = errors.New("peer did not present a certificate")
+	errTransportIdMismatch = errors.New("certificate of the peer does not belong to its id")
+)
+
+// prepares the configured transport, for TRANSPORT_TLS the certificate of the host key is created
+func (thisNode *Node) setupTransport() error {
+	switch thisNode.conf.Transport {
+	case "", TRANSPORT_TCP:
+		return nil
+	case TRANSPORT_TLS:
+	default:
+		return errors.New("unknown transport " + thisNode.conf.Transport + ", has to be " + TRANSPORT_TCP + " or " + TRANSPORT_TLS)
+	}
+	if thisNode.hostKey == nil {
+		return errors.New("transport " + TRANSPORT_TLS + " needs a host key")
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "DHT-16 peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(100 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, thisNode.hostKey.Public(), thisNode.hostKey)
+	if err != nil {
+		return errors.New("Could not create certificate for the host key: " + err.Error())
+	}
+	thisNode.tlsCertificate = &tls.Certificate{Certificate: [][]byte{certificate}, PrivateKey: thisNode.hostKey}
+	return nil
+}
+
+// returns the TLS configuration for the local node, expectedID is checked against the certificate of the peer if set
+func (thisNode *Node) tlsConfig(expectedID *id) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{*thisNode.tlsCertificate},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// the certificates are self-signed, instead of a chain the identity of the peer is checked
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errNoPeerCertificate
+			}
+			peerID, err := idOfCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if expectedID != nil && peerID != *expectedID {
+				return errTransportIdMismatch
+			}
+			return nil
+		},
+	}
+}
+
+// returns the ID belonging to the public key of the given certificate
+func idOfCertificate(rawCertificate []byte) (id, error) {
+	certificate, err := x509.ParseCertificate(rawCertificate)
+	if err != nil {
+		return id{}, errors.New("Could not parse certificate of the peer: " + err.Error())
+	}
+	return idOfPublicKey(certificate.RawSubjectPublicKeyInfo), nil
+}
+
+// listens for P2P connections at the given address with the configured transport
+func (thisNode *Node) listenP2P(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil || thisNode.tlsCertificate == nil {
+		return listener, err
+	}
+	return tls.NewListener(listener, thisNode.tlsConfig(nil)), nil
+}
+
+// connects to the given peer with the configured transport, the TLS handshake has to finish within REQUEST_TIMEOUT
+func (thisNode *Node) dialP2P(receiverPeer peer) (net.Conn, error) {
+	address := net.JoinHostPort(receiverPeer.ip, strconv.Itoa(int(receiverPeer.port)))
+	if thisNode.tlsCertificate == nil {
+		return net.DialTimeout("tcp", address, REQUEST_TIMEOUT)
+	}
+	// the id of bootstrap peers is not known before they answer
+	var expectedID *id
+	if receiverPeer.id != (id{}) {
+		expectedID = &receiverPeer.id
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: REQUEST_TIMEOUT}, "tcp", address, thisNode.tlsConfig(expectedID))
+}
+
+// checks that the peer of a TLS connection authenticated with the host key of senderID, plain TCP connections are not
+// checked
+func verifyConnectionIdentity(conn net.Conn, senderID id) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return errNoPeerCertificate
+	}
+	if idOfPublicKey(certificates[0].RawSubjectPublicKeyInfo) != senderID {
+		return errTransportIdMismatch
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"context"
+	"testing"
+)
+
+// builds a test node whose P2P connections use TLS
+func buildTestTLSNode(t *testing.T, prefix string, port uint16) *Node {
+	testNode := buildTestNode(prefix, port)
+	testNode.conf.Transport = TRANSPORT_TLS
+	if err := testNode.setupTransport(); err != nil {
+		t.Fatal("[FAILURE] could not set up TLS: ", err)
+	}
+	return testNode
+}
+
+// Test if peers reach each other over TLS and are added to the routing table of the receiver
+func TestTLSPing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := buildTestTLSNode(t, "1", 8090)
+	var err error
+	receiver.p2pListener, err = receiver.listenP2P("127.0.0.1:8090")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	receiver.wg.Add(1)
+	go receiver.startP2PMessageDispatcher(ctx)
+
+	sender := buildTestTLSNode(t, "0", 8091)
+	sender.p2pListener, err = sender.listenP2P("127.0.0.1:8091")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	sender.wg.Add(1)
+	go sender.startP2PMessageDispatcher(ctx)
+	if !sender.pingNode(receiver.thisPeer) {
+		t.Fatal("[FAILURE] ping over TLS failed")
+	}
+	// bootstrap peers are contacted without knowing their id
+	if !sender.pingNode(peer{ip: "127.0.0.1", port: 8090}) {
+		t.Errorf("[FAILURE] ping over TLS of a peer with unknown id failed")
+	}
+	waitForVerifications(t, receiver)
+	if len(receiver.knownPeers()) != 1 || receiver.knownPeers()[0].id != sender.thisPeer.id {
+		t.Errorf("[FAILURE] sender was not added to the routing table of the receiver")
+	}
+
+	// the peer at the address does not own the host key of the expected id
+	impostor := receiver.thisPeer
+	impostor.id = buildTestIdFromString("11")
+	if sender.pingNode(impostor) {
+		t.Errorf("[FAILURE] peer with a certificate of another id was accepted")
+	}
+
+	// plain TCP peers can not talk to TLS peers
+	plain := buildTestNode("0", 8092)
+	if plain.pingNode(receiver.thisPeer) {
+		t.Errorf("[FAILURE] plain TCP ping of a TLS peer succeeded")
+	}
+}
+
+// Test if a message whose sender differs from the peer authenticated in the TLS handshake is dropped
+func TestTLSSenderMismatch(t *testing.T) {
+	receiver := buildTestTLSNode(t, "1", 8093)
+	listener, err := receiver.listenP2P("127.0.0.1:8093")
+	if err != nil {
+		t.Fatal("[FAILURE] could not listen: ", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			receiver.handleP2PConnection(conn)
+		}
+	}()
+
+	// the connection is authenticated with one host key, the message is signed with another one
+	connecting := buildTestTLSNode(t, "0", 8094)
+	sender := buildTestNode("01", 8094)
+	conn, err := connecting.dialP2P(receiver.thisPeer)
+	if err != nil {
+		t.Fatal("[FAILURE] could not connect: ", err)
+	}
+	defer conn.Close()
+	if err = writeMessage(conn, sender.makeP2PMessageOutOfBody(nil, KDM_PING)); err != nil {
+		t.Fatal("[FAILURE] could not write message: ", err)
+	}
+	if _, err = readMessage(conn); err == nil {
+		t.Errorf("[FAILURE] message of another sender than the authenticated one was answered")
+	}
+	if len(receiver.knownPeers()) != 0 {
+		t.Errorf("[FAILURE] sender was added to the routing table")
+	}
+}
+
+// Test if an unknown transport is rejected
+func TestUnknownTransport(t *testing.T) {
+	testNode := buildTestNode("0", 8095)
+	testNode.conf.Transport = "noise"
+	if testNode.setupTransport() == nil {
+		t.Errorf("[FAILURE] unknown transport was accepted")
+	}
+}